@@ -12,12 +12,19 @@ import (
 type EPGStationClient struct {
 	baseURL string
 	client  *http.Client
+	// リバースプロキシの背後にある EPGStation へのリクエストに付与する認証情報とヘッダー
+	username string
+	password string
+	headers  map[string]string
 }
 
-func NewEPGStationClient(baseURL string) *EPGStationClient {
+func NewEPGStationClient(baseURL string, client *http.Client, username, password string, headers map[string]string) *EPGStationClient {
 	return &EPGStationClient{
-		baseURL: baseURL,
-		client:  &http.Client{},
+		baseURL:  baseURL,
+		client:   client,
+		username: username,
+		password: password,
+		headers:  headers,
 	}
 }
 
@@ -27,13 +34,52 @@ type EPGStationError struct {
 	Errors  string `json:"errors"`
 }
 
+// EPGStationUnreachableError は EPGStation に接続できなかったことを表す
+type EPGStationUnreachableError struct {
+	Err error
+}
+
+func (e *EPGStationUnreachableError) Error() string {
+	return fmt.Sprintf("epgstation is unreachable: %s", e.Err)
+}
+
+func (e *EPGStationUnreachableError) Unwrap() error {
+	return e.Err
+}
+
+// EPGStationHTTPError はリバースプロキシのエラーページなど、EPGStation 以外が返したエラー応答を表す
+type EPGStationHTTPError struct {
+	StatusCode int
+}
+
+func (e *EPGStationHTTPError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// EPGStationAPIError は EPGStation の API が返したエラーを表す
+type EPGStationAPIError struct {
+	EPGStationError
+}
+
+func (e *EPGStationAPIError) Error() string {
+	return fmt.Sprintf("api error: %d: %s, %s", e.Code, e.Message, e.Errors)
+}
+
 type EPGStationStreams struct {
-	Items []*struct {
-		Type string `json:"type"`
-	} `json:"items"`
+	Items []*EPGStationStream `json:"items"`
 	EPGStationError
 }
 
+type EPGStationStream struct {
+	StreamID   int    `json:"streamId"`
+	Type       string `json:"type"`
+	Mode       int    `json:"mode"`
+	IsEnable   bool   `json:"isEnable"`
+	ChannelID  int    `json:"channelId"`
+	RecordedID *int   `json:"recordedId"`
+	Name       string `json:"name"`
+}
+
 func (c *EPGStationClient) GetStreams(ctx context.Context) (*EPGStationStreams, error) {
 	var result EPGStationStreams
 	if err := c.get(ctx, "/api/streams?isHalfWidth=false", &result); err != nil {
@@ -41,7 +87,7 @@ func (c *EPGStationClient) GetStreams(ctx context.Context) (*EPGStationStreams,
 	}
 
 	if result.Code != 0 {
-		return nil, fmt.Errorf("failed to get streams: %d: %s, %s", result.Code, result.Message, result.Errors)
+		return nil, &EPGStationAPIError{EPGStationError: result.EPGStationError}
 	}
 
 	return &result, nil
@@ -62,12 +108,160 @@ func (c *EPGStationClient) GetReserveCounts(ctx context.Context) (*EPGStationRes
 	}
 
 	if result.Code != 0 {
-		return nil, fmt.Errorf("failed to get reserve counts: %d: %s, %s", result.Code, result.Message, result.Errors)
+		return nil, &EPGStationAPIError{EPGStationError: result.EPGStationError}
+	}
+
+	return &result, nil
+}
+
+type EPGStationReserves struct {
+	Reserves []*EPGStationReserve `json:"reserves"`
+	Total    int                  `json:"total"`
+	EPGStationError
+}
+
+type EPGStationReserve struct {
+	ID int `json:"id"`
+	// ルールに依らない手動予約では ruleId が返されない
+	RuleID     *int   `json:"ruleId"`
+	ChannelID  int    `json:"channelId"`
+	Name       string `json:"name"`
+	StartAt    int64  `json:"startAt"`
+	EndAt      int64  `json:"endAt"`
+	IsSkip     bool   `json:"isSkip"`
+	IsConflict bool   `json:"isConflict"`
+	IsOverlap  bool   `json:"isOverlap"`
+	// 録画先のディレクトリ名。省略された場合は最初のディレクトリに録画される
+	ParentDirectoryName *string `json:"parentDirectoryName"`
+}
+
+func (c *EPGStationClient) GetReserves(ctx context.Context) (*EPGStationReserves, error) {
+	var result EPGStationReserves
+	if err := c.get(ctx, "/api/reserves?isHalfWidth=false&type=all", &result); err != nil {
+		return nil, err
+	}
+
+	if result.Code != 0 {
+		return nil, &EPGStationAPIError{EPGStationError: result.EPGStationError}
+	}
+
+	return &result, nil
+}
+
+type EPGStationRecorded struct {
+	Records []*EPGStationRecordedItem `json:"records"`
+	Total   int                       `json:"total"`
+	EPGStationError
+}
+
+type EPGStationRecordedItem struct {
+	ID          int                    `json:"id"`
+	RuleID      *int                   `json:"ruleId"`
+	ChannelID   *int                   `json:"channelId"`
+	Name        string                 `json:"name"`
+	StartAt     int64                  `json:"startAt"`
+	EndAt       int64                  `json:"endAt"`
+	IsRecording bool                   `json:"isRecording"`
+	IsProtected bool                   `json:"isProtected"`
+	VideoFiles  []*EPGStationVideoFile `json:"videoFiles"`
+	Thumbnails  []int                  `json:"thumbnails"`
+	DropLogFile *EPGStationDropLogFile `json:"dropLogFile"`
+}
+
+type EPGStationVideoFile struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// ts または encoded
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+	// 保存先のストレージの名前
+	ParentDirectoryName *string `json:"parentDirectoryName"`
+}
+
+type EPGStationDropLogFile struct {
+	ID            int   `json:"id"`
+	ErrorCnt      int64 `json:"errorCnt"`
+	DropCnt       int64 `json:"dropCnt"`
+	ScramblingCnt int64 `json:"scramblingCnt"`
+}
+
+// 1 回のリクエストで取得する録画済み番組の件数
+const recordedPageSize = 100
+
+// GetRecorded は録画済み番組を新しい順に offset 件目から最大 limit 件取得する
+func (c *EPGStationClient) GetRecorded(ctx context.Context, offset, limit int) (*EPGStationRecorded, error) {
+	var result EPGStationRecorded
+	path := fmt.Sprintf("/api/recorded?isHalfWidth=false&offset=%d&limit=%d", offset, limit)
+	if err := c.get(ctx, path, &result); err != nil {
+		return nil, err
+	}
+
+	if result.Code != 0 {
+		return nil, &EPGStationAPIError{EPGStationError: result.EPGStationError}
 	}
 
 	return &result, nil
 }
 
+// GetAllRecorded は録画済み番組をページングしながらすべて取得する
+func (c *EPGStationClient) GetAllRecorded(ctx context.Context) ([]*EPGStationRecordedItem, error) {
+	var records []*EPGStationRecordedItem
+	for {
+		result, err := c.GetRecorded(ctx, len(records), recordedPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, result.Records...)
+		if len(result.Records) == 0 || len(records) >= result.Total {
+			return records, nil
+		}
+	}
+}
+
+type EPGStationRules struct {
+	Rules []*EPGStationRule `json:"rules"`
+	Total int               `json:"total"`
+	EPGStationError
+}
+
+type EPGStationRule struct {
+	ID            int                   `json:"id"`
+	SearchOption  *EPGStationRuleSearch `json:"searchOption"`
+	ReserveOption *struct {
+		Enable bool `json:"enable"`
+	} `json:"reserveOption"`
+}
+
+type EPGStationRuleSearch struct {
+	// ジャンルやチャンネルのみで絞り込むルールでは keyword が返されない
+	Keyword *string `json:"keyword"`
+}
+
+// 1 回のリクエストで取得するルールの件数
+const rulesPageSize = 100
+
+// GetAllRules はルールをページングしながらすべて取得する
+func (c *EPGStationClient) GetAllRules(ctx context.Context) ([]*EPGStationRule, error) {
+	var rules []*EPGStationRule
+	for {
+		var result EPGStationRules
+		path := fmt.Sprintf("/api/rules?offset=%d&limit=%d", len(rules), rulesPageSize)
+		if err := c.get(ctx, path, &result); err != nil {
+			return nil, err
+		}
+
+		if result.Code != 0 {
+			return nil, &EPGStationAPIError{EPGStationError: result.EPGStationError}
+		}
+
+		rules = append(rules, result.Rules...)
+		if len(result.Rules) == 0 || len(rules) >= result.Total {
+			return rules, nil
+		}
+	}
+}
+
 type EPGStationRecording struct {
 	Total int `json:"total"`
 	EPGStationError
@@ -80,18 +274,28 @@ func (c *EPGStationClient) GetRecording(ctx context.Context) (*EPGStationRecordi
 	}
 
 	if result.Code != 0 {
-		return nil, fmt.Errorf("failed to get recording: %d: %s, %s", result.Code, result.Message, result.Errors)
+		return nil, &EPGStationAPIError{EPGStationError: result.EPGStationError}
 	}
 
 	return &result, nil
 }
 
 type EPGStationEncode struct {
-	RunningItems []*struct{} `json:"runningItems"`
-	WaitItems    []*struct{} `json:"waitItems"`
+	RunningItems []*EPGStationEncodeItem `json:"runningItems"`
+	WaitItems    []*EPGStationEncodeItem `json:"waitItems"`
 	EPGStationError
 }
 
+type EPGStationEncodeItem struct {
+	ID int `json:"id"`
+	// エンコードプリセットの名前
+	Mode     string                  `json:"mode"`
+	Recorded *EPGStationRecordedItem `json:"recorded"`
+	// 実行中のエンコードでのみ返される
+	Percent *float64 `json:"percent"`
+	Log     *string  `json:"log"`
+}
+
 func (c *EPGStationClient) GetEncode(ctx context.Context) (*EPGStationEncode, error) {
 	var result EPGStationEncode
 	if err := c.get(ctx, "/api/encode?isHalfWidth=false", &result); err != nil {
@@ -99,7 +303,7 @@ func (c *EPGStationClient) GetEncode(ctx context.Context) (*EPGStationEncode, er
 	}
 
 	if result.Code != 0 {
-		return nil, fmt.Errorf("failed to get encode: %d: %s, %s", result.Code, result.Message, result.Errors)
+		return nil, &EPGStationAPIError{EPGStationError: result.EPGStationError}
 	}
 
 	return &result, nil
@@ -122,7 +326,7 @@ func (c *EPGStationClient) GetStorages(ctx context.Context) (*EPGStationStorages
 	}
 
 	if result.Code != 0 {
-		return nil, fmt.Errorf("failed to get storages: %d: %s, %s", result.Code, result.Message, result.Errors)
+		return nil, &EPGStationAPIError{EPGStationError: result.EPGStationError}
 	}
 
 	return &result, nil
@@ -134,18 +338,34 @@ func (c *EPGStationClient) get(ctx context.Context, path string, result any) err
 		return err
 	}
 
+	for key, value := range c.headers {
+		request.Header.Set(key, value)
+	}
 	request.Header.Set("User-Agent", "telegraf-input-epgstation (+https://github.com/SlashNephy/telegraf-plugins)")
+	if c.username != "" || c.password != "" {
+		request.SetBasicAuth(c.username, c.password)
+	}
 
-	response, err := http.DefaultClient.Do(request)
+	response, err := c.client.Do(request)
 	if err != nil {
-		return err
+		return &EPGStationUnreachableError{Err: err}
 	}
 
 	defer func() { _ = response.Body.Close() }()
 
 	body, err := io.ReadAll(response.Body)
 	if err != nil {
-		return err
+		return &EPGStationUnreachableError{Err: err}
+	}
+
+	if response.StatusCode != http.StatusOK {
+		// EPGStation 自身のエラーは JSON で返される
+		var apiError EPGStationError
+		if err = json.Unmarshal(body, &apiError); err == nil && apiError.Code != 0 {
+			return &EPGStationAPIError{EPGStationError: apiError}
+		}
+
+		return &EPGStationHTTPError{StatusCode: response.StatusCode}
 	}
 
 	if err = json.Unmarshal(body, &result); err != nil {
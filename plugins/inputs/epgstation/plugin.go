@@ -3,23 +3,79 @@ package epgstation
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/caarlos0/env/v11"
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/config"
+	"github.com/influxdata/telegraf/plugins/common/tls"
 	"github.com/influxdata/telegraf/plugins/inputs"
+	"github.com/samber/lo"
 	"golang.org/x/sync/errgroup"
 )
 
 //go:embed sample.conf
 var sampleConfig string
 
-const measurement = "epgstation"
+const (
+	measurement            = "epgstation"
+	reservesMeasurement    = "epgstation_reserves"
+	recordedMeasurement    = "epgstation_recorded"
+	dropLogsMeasurement    = "epgstation_drop_logs"
+	streamsMeasurement     = "epgstation_streams"
+	viewingMeasurement     = "epgstation_viewing"
+	consistencyMeasurement = "epgstation_consistency"
+	rulesMeasurement       = "epgstation_rules"
+	upMeasurement          = "epgstation_up"
+	endpointsMeasurement   = "epgstation_endpoints"
+
+	encodesMeasurement          = "epgstation_encodes"
+	encodeCompletionMeasurement = "epgstation_encode_completions"
+)
 
 type Plugin struct {
 	client *EPGStationClient
 
-	EPGStationBaseURL string `toml:"-" env:"EPGSTATION_BASE_URL" envDefault:"http://localhost:8888"`
+	mu sync.Mutex
+	// ドロップログを出力済みの録画済み番組の ID。初回の収集以前に録画された番組は出力しない
+	reportedDropLogIDs map[int]struct{}
+	// エンコードキューで観測したエンコードと、初めて観測した時刻
+	encodes map[int]*observedEncode
+	// ストレージごとの使用量の推移
+	storageSamples map[string][]storageSample
+	// 配信中のストリームと、初めて観測した時刻
+	streams map[int]time.Time
+	// 当日のチャンネルごとの視聴時間 (秒)
+	viewingSeconds map[int]float64
+	viewingDay     time.Time
+	lastStreamsAt  time.Time
+	// エンドポイントごとの直近のエラー
+	lastErrors map[string]string
+
+	EPGStationBaseURL string            `toml:"base_url" env:"EPGSTATION_BASE_URL" envDefault:"http://localhost:8888"`
+	Username          string            `toml:"username" env:"EPGSTATION_USERNAME"`
+	Password          string            `toml:"password" env:"EPGSTATION_PASSWORD"`
+	Headers           map[string]string `toml:"headers" env:"EPGSTATION_HEADERS"`
+	Timeout           config.Duration   `toml:"timeout" env:"EPGSTATION_TIMEOUT" envDefault:"10s"`
+	tls.ClientConfig
+
+	// この期間内に開始する予約を予約ごとに出力する
+	EPGStationReservesWindow time.Duration `toml:"-" env:"EPGSTATION_RESERVES_WINDOW" envDefault:"24h"`
+	// ストレージの使用量の増加速度を求める期間
+	EPGStationStorageForecastWindow time.Duration `toml:"-" env:"EPGSTATION_STORAGE_FORECAST_WINDOW" envDefault:"6h"`
+	// 予約の録画サイズの見積もりに使うビットレート (bps)。BS の最大ビットレート程度を既定値とする
+	EPGStationRecordingBitrate int64 `toml:"-" env:"EPGSTATION_RECORDING_BITRATE" envDefault:"24000000"`
+	// /api/recorded はライブラリ全体をページングして取得するため、録画済み番組の数に比例して重くなる
+	// 明示的に有効化した場合のみ、録画済み番組の統計とルールごとの録画済み番組数を収集する
+	// ドロップログは新しい録画済み番組のみを取得して常に収集する
+	EPGStationGatherRecorded bool `toml:"-" env:"EPGSTATION_GATHER_RECORDED" envDefault:"false"`
+	// 録画済み番組のファイルとストレージの整合性を検査する。録画済み番組をすべて取得し直すため既定では無効
+	EPGStationCheckConsistency bool `toml:"-" env:"EPGSTATION_CHECK_CONSISTENCY"`
 }
 
 func init() {
@@ -29,11 +85,30 @@ func init() {
 }
 
 func (p *Plugin) Init() error {
-	if err := env.Parse(p); err != nil {
+	// TOML で設定された値を環境変数の既定値で上書きしない
+	if err := env.ParseWithOptions(p, env.Options{SetDefaultsForZeroValuesOnly: true}); err != nil {
 		return fmt.Errorf("failed to parse env: %w", err)
 	}
 
-	p.client = NewEPGStationClient(p.EPGStationBaseURL)
+	if p.EPGStationStorageForecastWindow <= 0 {
+		return errors.New("storage forecast window must be positive")
+	}
+
+	tlsConfig, err := p.ClientConfig.TLSConfig()
+	if err != nil {
+		return fmt.Errorf("failed to create tls config: %w", err)
+	}
+
+	// 接続やハンドシェイクのタイムアウトなど、既定の設定を引き継ぐ
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	client := &http.Client{
+		Timeout:   time.Duration(p.Timeout),
+		Transport: transport,
+	}
+
+	p.client = NewEPGStationClient(p.EPGStationBaseURL, client, p.Username, p.Password, p.Headers)
 	return nil
 }
 
@@ -41,29 +116,137 @@ func (p *Plugin) SampleConfig() string {
 	return sampleConfig
 }
 
+type endpointGatherFunc struct {
+	endpoint string
+	f        func(context.Context, telegraf.Accumulator) error
+}
+
+// gatherSources は複数の収集処理で使う一覧を、1 回の Gather の間に 1 度だけ取得して共有する
+type gatherSources struct {
+	reserves func() (*EPGStationReserves, error)
+	recorded func() ([]*EPGStationRecordedItem, error)
+	// 新しい順の録画済み番組。ライブラリ全体を取得する場合はその結果を使う
+	latestRecorded func() ([]*EPGStationRecordedItem, error)
+	storages       func() (*EPGStationStorages, error)
+}
+
+func (p *Plugin) newGatherSources(ctx context.Context) *gatherSources {
+	sources := &gatherSources{
+		reserves: sync.OnceValues(func() (*EPGStationReserves, error) {
+			return p.client.GetReserves(ctx)
+		}),
+		recorded: sync.OnceValues(func() ([]*EPGStationRecordedItem, error) {
+			return p.client.GetAllRecorded(ctx)
+		}),
+		storages: sync.OnceValues(func() (*EPGStationStorages, error) {
+			return p.client.GetStorages(ctx)
+		}),
+	}
+	sources.latestRecorded = sync.OnceValues(func() ([]*EPGStationRecordedItem, error) {
+		if p.EPGStationGatherRecorded {
+			return sources.recorded()
+		}
+
+		recorded, err := p.client.GetRecorded(ctx, 0, recordedPageSize)
+		if err != nil {
+			return nil, err
+		}
+		return recorded.Records, nil
+	})
+
+	return sources
+}
+
 func (p *Plugin) Gather(accumulator telegraf.Accumulator) error {
 	var eg errgroup.Group
 	ctx := context.Background()
+	sources := p.newGatherSources(ctx)
+
+	withSources := func(f func(context.Context, telegraf.Accumulator, *gatherSources) error) func(context.Context, telegraf.Accumulator) error {
+		return func(ctx context.Context, accumulator telegraf.Accumulator) error {
+			return f(ctx, accumulator, sources)
+		}
+	}
 
-	getherFuncs := []func(context.Context, telegraf.Accumulator) error{
-		p.gatherStreamMetrics,
-		p.gatherReserveCountsMetrics,
-		p.gatherRecordingMetrics,
-		p.gatherEncodeMetrics,
-		p.gatherStoragesMetrics,
+	getherFuncs := []endpointGatherFunc{
+		{"streams", p.gatherStreamMetrics},
+		{"reserve_counts", p.gatherReserveCountsMetrics},
+		{"reserves", withSources(p.gatherReservesMetrics)},
+		{"recording", p.gatherRecordingMetrics},
+		{"rules", withSources(p.gatherRulesMetrics)},
+		{"encode", p.gatherEncodeMetrics},
+		{"drop_logs", withSources(p.gatherDropLogMetrics)},
+		{"storages", withSources(p.gatherStoragesMetrics)},
 	}
-	for _, f := range getherFuncs {
+	if p.EPGStationGatherRecorded {
+		getherFuncs = append(getherFuncs, endpointGatherFunc{"recorded", withSources(p.gatherRecordedMetrics)})
+	}
+	if p.EPGStationCheckConsistency {
+		getherFuncs = append(getherFuncs, endpointGatherFunc{"consistency", withSources(p.gatherConsistencyMetrics)})
+	}
+	errs := make([]error, len(getherFuncs))
+	for index, g := range getherFuncs {
 		eg.Go(func() error {
-			return f(ctx, accumulator)
+			errs[index] = g.f(ctx, accumulator)
+			return nil
 		})
 	}
-	if err := eg.Wait(); err != nil {
-		return fmt.Errorf("failed to gather metrics: %w", err)
+	_ = eg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.lastErrors == nil {
+		p.lastErrors = make(map[string]string, len(getherFuncs))
+	}
+
+	// 一部のエンドポイントが失敗しても Gather 全体は失敗させず、エンドポイントごとの状態として記録する
+	// 接続できない場合やリバースプロキシがエラーページを返す場合を除き、いずれかのエンドポイントが
+	// EPGStation 自身から応答を得られれば稼働しているとみなす
+	up := false
+	for index, g := range getherFuncs {
+		err := errs[index]
+
+		fields := map[string]any{
+			"ok": err == nil,
+		}
+		if err == nil || errors.As(err, new(*EPGStationAPIError)) {
+			up = true
+		}
+		if err != nil {
+			accumulator.AddError(fmt.Errorf("failed to gather %s metrics: %w", g.endpoint, err))
+
+			fields["error_type"] = errorType(err)
+			p.lastErrors[g.endpoint] = err.Error()
+		}
+		if lastError, ok := p.lastErrors[g.endpoint]; ok {
+			fields["last_error"] = lastError
+		}
+
+		accumulator.AddFields(endpointsMeasurement, fields, map[string]string{
+			"endpoint": g.endpoint,
+		})
 	}
 
+	accumulator.AddFields(upMeasurement, map[string]any{
+		"up": up,
+	}, nil)
 	return nil
 }
 
+func errorType(err error) string {
+	switch {
+	case errors.As(err, new(*EPGStationUnreachableError)):
+		return "unreachable"
+	case errors.As(err, new(*EPGStationHTTPError)):
+		return "http"
+	case errors.As(err, new(*EPGStationAPIError)):
+		return "api"
+	default:
+		return "other"
+	}
+}
+
 func (p *Plugin) gatherStreamMetrics(ctx context.Context, accumulator telegraf.Accumulator) error {
 	streams, err := p.client.GetStreams(ctx)
 	if err != nil {
@@ -95,9 +278,75 @@ func (p *Plugin) gatherStreamMetrics(ctx context.Context, accumulator telegraf.A
 		"stream_recorded":     recordedStreams,
 		"stream_recorded_hls": recordedHLS,
 	}, nil)
+
+	p.addStreamSessionMetrics(accumulator, streams.Items, time.Now())
 	return nil
 }
 
+func (p *Plugin) addStreamSessionMetrics(accumulator telegraf.Accumulator, streams []*EPGStationStream, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// 視聴時間は日ごとに集計し直す
+	year, month, day := now.Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+	if p.viewingSeconds == nil || !p.viewingDay.Equal(today) {
+		p.viewingSeconds = make(map[int]float64)
+		p.viewingDay = today
+	}
+
+	// 前回の収集から配信が続いているストリームだけ、その間の時間を視聴時間に加える
+	var elapsed time.Duration
+	if !p.lastStreamsAt.IsZero() {
+		since := p.lastStreamsAt
+		if since.Before(today) {
+			since = today
+		}
+		elapsed = now.Sub(since)
+	}
+	p.lastStreamsAt = now
+
+	streamsSeen := make(map[int]time.Time, len(streams))
+	for _, stream := range streams {
+		firstSeen, ok := p.streams[stream.StreamID]
+		if ok {
+			p.viewingSeconds[stream.ChannelID] += elapsed.Seconds()
+		} else {
+			firstSeen = now
+			if _, ok := p.viewingSeconds[stream.ChannelID]; !ok {
+				p.viewingSeconds[stream.ChannelID] = 0
+			}
+		}
+		streamsSeen[stream.StreamID] = firstSeen
+
+		// ストリーム ID や録画済み番組の ID をタグにすると視聴のたびに系列が増え続けるため、フィールドとして出力する
+		fields := map[string]any{
+			"stream_id":     stream.StreamID,
+			"name":          stream.Name,
+			"enabled":       stream.IsEnable,
+			"alive_seconds": now.Sub(firstSeen).Seconds(),
+		}
+		if stream.RecordedID != nil {
+			fields["recorded_id"] = *stream.RecordedID
+		}
+
+		accumulator.AddFields(streamsMeasurement, fields, map[string]string{
+			"type":       stream.Type,
+			"mode":       strconv.Itoa(stream.Mode),
+			"channel_id": strconv.Itoa(stream.ChannelID),
+		})
+	}
+	p.streams = streamsSeen
+
+	for channelID, seconds := range p.viewingSeconds {
+		accumulator.AddFields(viewingMeasurement, map[string]any{
+			"minutes": seconds / 60,
+		}, map[string]string{
+			"channel_id": strconv.Itoa(channelID),
+		})
+	}
+}
+
 func (p *Plugin) gatherReserveCountsMetrics(ctx context.Context, accumulator telegraf.Accumulator) error {
 	counts, err := p.client.GetReserveCounts(ctx)
 	if err != nil {
@@ -113,6 +362,94 @@ func (p *Plugin) gatherReserveCountsMetrics(ctx context.Context, accumulator tel
 	return nil
 }
 
+func (p *Plugin) gatherReservesMetrics(_ context.Context, accumulator telegraf.Accumulator, sources *gatherSources) error {
+	reserves, err := sources.reserves()
+	if err != nil {
+		return fmt.Errorf("failed to get reserves: %w", err)
+	}
+
+	now := time.Now()
+	for _, reserve := range reserves.Reserves {
+		startAt := time.UnixMilli(reserve.StartAt)
+		endAt := time.UnixMilli(reserve.EndAt)
+
+		// 既に終了した予約と、期間より先に開始する予約は対象外
+		if !endAt.After(now) || startAt.After(now.Add(p.EPGStationReservesWindow)) {
+			continue
+		}
+
+		// 予約 ID をタグにすると予約のたびに系列が増え続けるため、フィールドとして出力する
+		tags := map[string]string{
+			"channel_id": strconv.Itoa(reserve.ChannelID),
+		}
+		if reserve.RuleID != nil {
+			tags["rule_id"] = strconv.Itoa(*reserve.RuleID)
+		}
+
+		accumulator.AddFields(reservesMeasurement, map[string]any{
+			"reserve_id":          reserve.ID,
+			"name":                reserve.Name,
+			"minutes_until_start": int64(startAt.Sub(now) / time.Minute),
+			"duration_minutes":    int64(endAt.Sub(startAt) / time.Minute),
+			"is_conflict":         reserve.IsConflict,
+			"is_skip":             reserve.IsSkip,
+			"is_overlap":          reserve.IsOverlap,
+		}, tags)
+	}
+	return nil
+}
+
+func (p *Plugin) gatherRulesMetrics(ctx context.Context, accumulator telegraf.Accumulator, sources *gatherSources) error {
+	rules, err := p.client.GetAllRules(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get rules: %w", err)
+	}
+
+	reserves, err := sources.reserves()
+	if err != nil {
+		return fmt.Errorf("failed to get reserves: %w", err)
+	}
+
+	reservesByRule := lo.CountValuesBy(lo.Filter(reserves.Reserves, func(r *EPGStationReserve, _ int) bool { return r.RuleID != nil }), func(r *EPGStationReserve) int { return *r.RuleID })
+
+	var recordedByRule map[int]int
+	if p.EPGStationGatherRecorded {
+		records, err := sources.recorded()
+		if err != nil {
+			return fmt.Errorf("failed to get recorded: %w", err)
+		}
+
+		recordedByRule = lo.CountValuesBy(lo.Filter(records, func(r *EPGStationRecordedItem, _ int) bool { return r.RuleID != nil }), func(r *EPGStationRecordedItem) int { return *r.RuleID })
+	}
+
+	isEnabled := func(r *EPGStationRule) bool { return r.ReserveOption != nil && r.ReserveOption.Enable }
+	accumulator.AddFields(measurement, map[string]any{
+		"rules_enabled":  lo.CountBy(rules, isEnabled),
+		"rules_disabled": lo.CountBy(rules, func(r *EPGStationRule) bool { return !isEnabled(r) }),
+	}, nil)
+
+	// 一度も予約されないルールや、予約を大量に生むルールを見つけられるよう、ルールごとに出力する
+	for _, rule := range rules {
+		tags := map[string]string{
+			"rule_id": strconv.Itoa(rule.ID),
+		}
+		if rule.SearchOption != nil && rule.SearchOption.Keyword != nil {
+			tags["keyword"] = *rule.SearchOption.Keyword
+		}
+
+		fields := map[string]any{
+			"enabled":  isEnabled(rule),
+			"reserves": reservesByRule[rule.ID],
+		}
+		if recordedByRule != nil {
+			fields["recorded"] = recordedByRule[rule.ID]
+		}
+
+		accumulator.AddFields(rulesMeasurement, fields, tags)
+	}
+	return nil
+}
+
 func (p *Plugin) gatherRecordingMetrics(ctx context.Context, accumulator telegraf.Accumulator) error {
 	recording, err := p.client.GetRecording(ctx)
 	if err != nil {
@@ -125,6 +462,134 @@ func (p *Plugin) gatherRecordingMetrics(ctx context.Context, accumulator telegra
 	return nil
 }
 
+type recordedKey struct {
+	channelID string
+	ruleID    string
+}
+
+func (p *Plugin) gatherRecordedMetrics(_ context.Context, accumulator telegraf.Accumulator, sources *gatherSources) error {
+	records, err := sources.recorded()
+	if err != nil {
+		return fmt.Errorf("failed to get recorded: %w", err)
+	}
+
+	// 番組ごとに出力すると系列が増え続けるため、チャンネルとルールごとに集計する
+	groups := lo.GroupBy(records, func(r *EPGStationRecordedItem) recordedKey {
+		var key recordedKey
+		if r.ChannelID != nil {
+			key.channelID = strconv.Itoa(*r.ChannelID)
+		}
+		if r.RuleID != nil {
+			key.ruleID = strconv.Itoa(*r.RuleID)
+		}
+		return key
+	})
+
+	for key, items := range groups {
+		var tsBytes, encodedBytes, dropErrors, drops, scramblings int64
+		for _, item := range items {
+			for _, file := range item.VideoFiles {
+				switch file.Type {
+				case "ts":
+					tsBytes += file.Size
+				case "encoded":
+					encodedBytes += file.Size
+				}
+			}
+
+			if item.DropLogFile != nil {
+				dropErrors += item.DropLogFile.ErrorCnt
+				drops += item.DropLogFile.DropCnt
+				scramblings += item.DropLogFile.ScramblingCnt
+			}
+		}
+
+		tags := make(map[string]string)
+		if key.channelID != "" {
+			tags["channel_id"] = key.channelID
+		}
+		if key.ruleID != "" {
+			tags["rule_id"] = key.ruleID
+		}
+
+		accumulator.AddFields(recordedMeasurement, map[string]any{
+			"recorded":             len(items),
+			"recording":            lo.CountBy(items, func(r *EPGStationRecordedItem) bool { return r.IsRecording }),
+			"protected":            lo.CountBy(items, func(r *EPGStationRecordedItem) bool { return r.IsProtected }),
+			"ts_bytes":             tsBytes,
+			"encoded_bytes":        encodedBytes,
+			"drop_log_errors":      dropErrors,
+			"drop_log_drops":       drops,
+			"drop_log_scramblings": scramblings,
+		}, tags)
+	}
+
+	return nil
+}
+
+func (p *Plugin) gatherDropLogMetrics(_ context.Context, accumulator telegraf.Accumulator, sources *gatherSources) error {
+	// 収集間隔の間に録画が終わる番組は、新しい順の最初のページに含まれる
+	records, err := sources.latestRecorded()
+	if err != nil {
+		return fmt.Errorf("failed to get recorded: %w", err)
+	}
+
+	p.addDropLogMetrics(accumulator, records)
+	return nil
+}
+
+// addDropLogMetrics は録画が終了した番組ごとに 1 度だけドロップログの内容を出力する
+// アンテナの劣化を録画ごとの推移として追えるよう、録画の終了時刻で記録する
+// EPGStation の API は録画に使用したチューナーを返さないため、チャンネルとルールのみをタグとする
+func (p *Plugin) addDropLogMetrics(accumulator telegraf.Accumulator, records []*EPGStationRecordedItem) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// 起動時に既存のライブラリ全体を出力しないよう、初回の収集では出力済みとして記録するだけにする
+	initial := p.reportedDropLogIDs == nil
+
+	// 削除された番組や最初のページから外れた番組の ID を保持し続けないよう、現在の録画済み番組から作り直す
+	// 外れた番組が再び含まれて出力し直されても、同じタグと時刻のため同じ点として上書きされる
+	reported := make(map[int]struct{}, len(records))
+	for _, record := range records {
+		if record.IsRecording || record.DropLogFile == nil {
+			continue
+		}
+
+		_, ok := p.reportedDropLogIDs[record.ID]
+		reported[record.ID] = struct{}{}
+		if ok || initial {
+			continue
+		}
+
+		tags := make(map[string]string)
+		if record.ChannelID != nil {
+			tags["channel_id"] = strconv.Itoa(*record.ChannelID)
+		}
+		if record.RuleID != nil {
+			tags["rule_id"] = strconv.Itoa(*record.RuleID)
+		}
+
+		accumulator.AddFields(dropLogsMeasurement, map[string]any{
+			"recorded_id": record.ID,
+			"name":        record.Name,
+			"errors":      record.DropLogFile.ErrorCnt,
+			"drops":       record.DropLogFile.DropCnt,
+			"scramblings": record.DropLogFile.ScramblingCnt,
+		}, tags, time.UnixMilli(record.EndAt))
+	}
+
+	p.reportedDropLogIDs = reported
+}
+
+type observedEncode struct {
+	mode       string
+	recordedID int
+	firstSeen  time.Time
+	// 初回の収集より前からキューにあったエンコードは、キューに入った時刻が分からない
+	enqueuedBeforeStart bool
+}
+
 func (p *Plugin) gatherEncodeMetrics(ctx context.Context, accumulator telegraf.Accumulator) error {
 	encode, err := p.client.GetEncode(ctx)
 	if err != nil {
@@ -135,27 +600,226 @@ func (p *Plugin) gatherEncodeMetrics(ctx context.Context, accumulator telegraf.A
 		"encode_running": len(encode.RunningItems),
 		"encode_waiting": len(encode.WaitItems),
 	}, nil)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	initial := p.encodes == nil
+	observed := make(map[int]*observedEncode, len(encode.RunningItems)+len(encode.WaitItems))
+
+	for status, items := range map[string][]*EPGStationEncodeItem{
+		"running": encode.RunningItems,
+		"waiting": encode.WaitItems,
+	} {
+		for _, item := range items {
+			o, ok := p.encodes[item.ID]
+			if !ok {
+				o = &observedEncode{
+					mode:                item.Mode,
+					firstSeen:           now,
+					enqueuedBeforeStart: initial,
+				}
+				if item.Recorded != nil {
+					o.recordedID = item.Recorded.ID
+				}
+			}
+			observed[item.ID] = o
+
+			// 滞留しているエンコードを見つけられるよう、キューに入ってからの経過時間を出力する
+			// エンコード ID をタグにするとエンコードのたびに系列が増え続けるため、フィールドとして出力する
+			fields := map[string]any{
+				"encode_id":       item.ID,
+				"elapsed_seconds": int64(now.Sub(o.firstSeen) / time.Second),
+			}
+			if item.Percent != nil {
+				fields["percent"] = *item.Percent
+			}
+			if item.Log != nil {
+				fields["log"] = *item.Log
+			}
+			if item.Recorded != nil {
+				fields["recorded_id"] = item.Recorded.ID
+				fields["name"] = item.Recorded.Name
+			}
+
+			accumulator.AddFields(encodesMeasurement, fields, map[string]string{
+				"mode":   item.Mode,
+				"status": status,
+			})
+		}
+	}
+
+	// キューから消えたエンコードは完了したとみなし、収集間隔の精度でキューに入ってから完了するまでの時間を出力する
+	// API からはキャンセルされたエンコードと区別できない
+	for id, o := range p.encodes {
+		if _, ok := observed[id]; ok || o.enqueuedBeforeStart {
+			continue
+		}
+
+		accumulator.AddFields(encodeCompletionMeasurement, map[string]any{
+			"encode_id":        id,
+			"recorded_id":      o.recordedID,
+			"duration_seconds": int64(now.Sub(o.firstSeen) / time.Second),
+		}, map[string]string{
+			"mode": o.mode,
+		})
+	}
+
+	p.encodes = observed
 	return nil
 }
 
-func (p *Plugin) gatherStoragesMetrics(ctx context.Context, accumulator telegraf.Accumulator) error {
-	storages, err := p.client.GetStorages(ctx)
+type storageSample struct {
+	at   time.Time
+	used int64
+}
+
+func (p *Plugin) gatherStoragesMetrics(_ context.Context, accumulator telegraf.Accumulator, sources *gatherSources) error {
+	storages, err := sources.storages()
 	if err != nil {
 		return fmt.Errorf("failed to get storages: %w", err)
 	}
 
+	// 予約を取得できなくても容量は出力し、予約の録画サイズの見積もりのみを省く
+	// 予約の取得の失敗は reserves の収集処理で記録される
+	now := time.Now()
+	var upcomingBytes map[string]int64
+	if reserves, err := sources.reserves(); err == nil {
+		upcomingBytes = p.estimateUpcomingReservesBytes(reserves.Reserves, storages, now)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.storageSamples == nil {
+		p.storageSamples = make(map[string][]storageSample)
+	}
+
 	for _, storage := range storages.Items {
-		accumulator.AddFields(measurement, map[string]any{
+		fields := map[string]any{
 			"storage_total":     storage.Total,
 			"storage_used":      storage.Used,
 			"storage_available": storage.Available,
-		}, map[string]string{
+		}
+		if upcomingBytes != nil {
+			fields["storage_upcoming_reserves_bytes"] = upcomingBytes[storage.Name]
+		}
+
+		// 期間外の古い記録を捨て、期間内の最初と最後の使用量から増加速度を求める
+		samples := append(p.storageSamples[storage.Name], storageSample{at: now, used: int64(storage.Used)})
+		samples = lo.DropWhile(samples, func(s storageSample) bool {
+			return now.Sub(s.at) > p.EPGStationStorageForecastWindow
+		})
+		p.storageSamples[storage.Name] = samples
+
+		if first, last := samples[0], samples[len(samples)-1]; last.at.After(first.at) {
+			fillRate := float64(last.used-first.used) / last.at.Sub(first.at).Hours()
+			fields["storage_fill_rate_bytes_per_hour"] = fillRate
+
+			// 使用量が増えていない場合は満杯になる時刻を見積もれない
+			if fillRate > 0 {
+				fields["storage_hours_until_full"] = float64(storage.Available) / fillRate
+			}
+		}
+
+		accumulator.AddFields(measurement, fields, map[string]string{
 			"storage_name": storage.Name,
 		})
 	}
 	return nil
 }
 
+// estimateUpcomingReservesBytes は予約期間内に開始する予約の録画サイズをストレージごとに見積もる
+func (p *Plugin) estimateUpcomingReservesBytes(reserves []*EPGStationReserve, storages *EPGStationStorages, now time.Time) map[string]int64 {
+	results := make(map[string]int64, len(storages.Items))
+	if len(storages.Items) == 0 {
+		return results
+	}
+
+	for _, reserve := range reserves {
+		// 録画されない予約は対象外
+		if reserve.IsSkip || reserve.IsConflict {
+			continue
+		}
+
+		startAt := time.UnixMilli(reserve.StartAt)
+		endAt := time.UnixMilli(reserve.EndAt)
+		if !endAt.After(now) || startAt.After(now.Add(p.EPGStationReservesWindow)) {
+			continue
+		}
+
+		// 録画中の予約は残りの時間のみを見積もる
+		if startAt.Before(now) {
+			startAt = now
+		}
+		duration := endAt.Sub(startAt)
+
+		storage := lo.FromPtrOr(reserve.ParentDirectoryName, storages.Items[0].Name)
+		results[storage] += int64(duration.Seconds() * float64(p.EPGStationRecordingBitrate) / 8)
+	}
+
+	return results
+}
+
+func (p *Plugin) gatherConsistencyMetrics(_ context.Context, accumulator telegraf.Accumulator, sources *gatherSources) error {
+	records, err := sources.recorded()
+	if err != nil {
+		return fmt.Errorf("failed to get recorded: %w", err)
+	}
+
+	storages, err := sources.storages()
+	if err != nil {
+		return fmt.Errorf("failed to get storages: %w", err)
+	}
+
+	var withoutVideo, withoutThumbnails, zeroByteFiles int
+	videoFiles := make(map[string]int, len(storages.Items))
+	videoBytes := make(map[string]int64, len(storages.Items))
+	for _, record := range records {
+		// 録画中の番組はファイルやサムネイルがまだ揃っていない
+		if record.IsRecording {
+			continue
+		}
+
+		if len(record.VideoFiles) == 0 {
+			withoutVideo++
+		}
+		if len(record.Thumbnails) == 0 {
+			withoutThumbnails++
+		}
+
+		for _, file := range record.VideoFiles {
+			if file.Size == 0 {
+				zeroByteFiles++
+			}
+			if file.ParentDirectoryName != nil {
+				videoFiles[*file.ParentDirectoryName]++
+				videoBytes[*file.ParentDirectoryName] += file.Size
+			}
+		}
+	}
+
+	accumulator.AddFields(consistencyMeasurement, map[string]any{
+		"recordings_without_video":      withoutVideo,
+		"recordings_without_thumbnails": withoutThumbnails,
+		"zero_byte_files":               zeroByteFiles,
+	}, nil)
+
+	// 使用量のうち録画済み番組のファイルで説明できない分が増え続ける場合、孤立したファイルが残っている
+	for _, storage := range storages.Items {
+		accumulator.AddFields(consistencyMeasurement, map[string]any{
+			"video_files":     videoFiles[storage.Name],
+			"video_bytes":     videoBytes[storage.Name],
+			"untracked_bytes": int64(storage.Used) - videoBytes[storage.Name],
+		}, map[string]string{
+			"storage": storage.Name,
+		})
+	}
+
+	return nil
+}
+
 var (
 	_ telegraf.Initializer = new(Plugin)
 	_ telegraf.Input       = new(Plugin)
@@ -0,0 +1,683 @@
+package epgstation
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/stretchr/testify/require"
+)
+
+// testAccumulator は AddFields で記録されたメトリクスを検証するための最小実装
+// telegraf/testutil は testcontainers に依存し依存関係が重いため、ここでは埋め込みで interface を満たす
+type testAccumulator struct {
+	telegraf.Accumulator
+
+	mu      sync.Mutex
+	metrics []testMetric
+	errors  []error
+}
+
+type testMetric struct {
+	measurement string
+	fields      map[string]any
+	tags        map[string]string
+	timestamp   time.Time
+}
+
+func (a *testAccumulator) AddFields(measurement string, fields map[string]any, tags map[string]string, timestamp ...time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	metric := testMetric{measurement: measurement, fields: fields, tags: tags}
+	if len(timestamp) > 0 {
+		metric.timestamp = timestamp[0]
+	}
+	a.metrics = append(a.metrics, metric)
+}
+
+func (a *testAccumulator) AddError(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.errors = append(a.errors, err)
+}
+
+// metricsOf は指定した measurement のメトリクスだけを取り出す
+func (a *testAccumulator) metricsOf(measurement string) []testMetric {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var results []testMetric
+	for _, metric := range a.metrics {
+		if metric.measurement == measurement {
+			results = append(results, metric)
+		}
+	}
+	return results
+}
+
+var _ telegraf.Accumulator = new(testAccumulator)
+
+// EPGStation v2 が返すレスポンス
+var defaultResponses = map[string]string{
+	"/api/streams":       `{ "items": [] }`,
+	"/api/reserves/cnts": `{ "normal": 3, "conflicts": 1, "skips": 0, "overlaps": 0 }`,
+	"/api/reserves":      `{ "reserves": [{ "id": 1, "ruleId": 10, "channelId": 3273601024, "name": "番組", "startAt": 0, "endAt": 1, "isSkip": false, "isConflict": false, "isOverlap": false }], "total": 1 }`,
+	"/api/recording":     `{ "records": [], "total": 0 }`,
+	"/api/recorded": `{ "records": [
+    { "id": 100, "ruleId": 10, "channelId": 3273601024, "name": "録画済み", "startAt": 0, "endAt": 1, "isRecording": false, "isProtected": false,
+      "videoFiles": [{ "id": 1, "name": "TS", "type": "ts", "size": 1000, "parentDirectoryName": "recorded" }], "thumbnails": [1] },
+    { "id": 101, "channelId": 3273601024, "name": "ファイルなし", "startAt": 0, "endAt": 1, "isRecording": false, "isProtected": true,
+      "videoFiles": [], "thumbnails": [] }
+  ], "total": 2 }`,
+	"/api/rules":    `{ "rules": [{ "id": 10, "searchOption": { "keyword": "ニュース" }, "reserveOption": { "enable": true } }], "total": 1 }`,
+	"/api/encode":   `{ "runningItems": [], "waitItems": [] }`,
+	"/api/storages": `{ "items": [{ "name": "recorded", "available": 9000, "used": 1500, "total": 10500 }] }`,
+}
+
+// testServer はパスごとのレスポンスを差し替えられ、リクエスト回数を記録する EPGStation のモック
+type testServer struct {
+	mu        sync.Mutex
+	responses map[string]string
+	requests  map[string]int
+	// 直近のリクエストのヘッダー
+	header http.Header
+}
+
+func (s *testServer) setResponse(path, response string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.responses[path] = response
+}
+
+func (s *testServer) lastHeader() http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.header
+}
+
+func (s *testServer) requestsOf(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[path]
+}
+
+func newTestPlugin(t *testing.T) (*Plugin, *testServer) {
+	t.Helper()
+
+	server := &testServer{
+		responses: make(map[string]string, len(defaultResponses)),
+		requests:  make(map[string]int),
+	}
+	for path, response := range defaultResponses {
+		server.responses[path] = response
+	}
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		response, ok := server.responses[r.URL.Path]
+		server.requests[r.URL.Path]++
+		server.header = r.Header.Clone()
+		server.mu.Unlock()
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(httpServer.Close)
+
+	t.Setenv("EPGSTATION_BASE_URL", httpServer.URL)
+
+	plugin := &Plugin{}
+	require.NoError(t, plugin.Init())
+	return plugin, server
+}
+
+func TestPluginGather(t *testing.T) {
+	t.Setenv("EPGSTATION_GATHER_RECORDED", "true")
+	t.Setenv("EPGSTATION_CHECK_CONSISTENCY", "true")
+	plugin, server := newTestPlugin(t)
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.Gather(&accumulator))
+	require.Empty(t, accumulator.errors)
+
+	// 複数の収集処理で使う一覧は 1 回の Gather で 1 度だけ取得する
+	require.Equal(t, 1, server.requestsOf("/api/reserves"))
+	require.Equal(t, 1, server.requestsOf("/api/recorded"))
+	require.Equal(t, 1, server.requestsOf("/api/storages"))
+
+	up := accumulator.metricsOf(upMeasurement)
+	require.Len(t, up, 1)
+	require.Equal(t, map[string]any{"up": true}, up[0].fields)
+
+	rules := accumulator.metricsOf(rulesMeasurement)
+	require.Len(t, rules, 1)
+	require.Equal(t, map[string]any{"enabled": true, "reserves": 1, "recorded": 1}, rules[0].fields)
+
+	consistency := accumulator.metricsOf(consistencyMeasurement)
+	require.Len(t, consistency, 2)
+	require.Equal(t, map[string]any{
+		"recordings_without_video":      1,
+		"recordings_without_thumbnails": 1,
+		"zero_byte_files":               0,
+	}, consistency[0].fields)
+	require.Equal(t, map[string]any{
+		"video_files":     1,
+		"video_bytes":     int64(1000),
+		"untracked_bytes": int64(500),
+	}, consistency[1].fields)
+}
+
+func TestAddStreamSessionMetrics(t *testing.T) {
+	var (
+		plugin      Plugin
+		accumulator testAccumulator
+	)
+	recordedID := 100
+	live := &EPGStationStream{StreamID: 1, Type: "LiveHLS", Mode: 2, IsEnable: true, ChannelID: 10, Name: "ニュース"}
+	recorded := &EPGStationStream{StreamID: 2, Type: "RecordedHLS", Mode: 0, IsEnable: true, ChannelID: 20, RecordedID: &recordedID}
+
+	startedAt := time.Date(2026, 10, 17, 23, 50, 0, 0, time.Local)
+	plugin.addStreamSessionMetrics(&accumulator, []*EPGStationStream{live}, startedAt)
+	plugin.addStreamSessionMetrics(&accumulator, []*EPGStationStream{live, recorded}, startedAt.Add(5*time.Minute))
+
+	streams := accumulator.metricsOf(streamsMeasurement)
+	require.Len(t, streams, 3)
+	require.Equal(t, map[string]string{"type": "LiveHLS", "mode": "2", "channel_id": "10"}, streams[1].tags)
+	require.Equal(t, map[string]any{"stream_id": 1, "name": "ニュース", "enabled": true, "alive_seconds": 300.0}, streams[1].fields)
+	require.Equal(t, map[string]any{"stream_id": 2, "recorded_id": 100, "name": "", "enabled": true, "alive_seconds": 0.0}, streams[2].fields)
+
+	viewing := func(accumulator *testAccumulator) map[string]any {
+		results := make(map[string]any)
+		for _, metric := range accumulator.metricsOf(viewingMeasurement) {
+			results[metric.tags["channel_id"]] = metric.fields["minutes"]
+		}
+		return results
+	}
+	require.Equal(t, map[string]any{"10": 5.0, "20": 0.0}, viewing(&accumulator))
+
+	// 日付が変わると、日付が変わってからの時間だけを数え直す
+	var nextDay testAccumulator
+	plugin.addStreamSessionMetrics(&nextDay, []*EPGStationStream{live}, startedAt.Add(15*time.Minute))
+	require.Equal(t, map[string]any{"10": 5.0}, viewing(&nextDay))
+	require.Equal(t, 900.0, nextDay.metricsOf(streamsMeasurement)[0].fields["alive_seconds"])
+
+	// 視聴が終わったチャンネルも、当日中は視聴時間を出力し続ける
+	var ended testAccumulator
+	plugin.addStreamSessionMetrics(&ended, nil, startedAt.Add(20*time.Minute))
+	require.Empty(t, ended.metricsOf(streamsMeasurement))
+	require.Equal(t, map[string]any{"10": 5.0}, viewing(&ended))
+}
+
+func TestAddDropLogMetrics(t *testing.T) {
+	var (
+		plugin      Plugin
+		accumulator testAccumulator
+	)
+	channelID := 10
+	record := func(id int, isRecording bool) *EPGStationRecordedItem {
+		return &EPGStationRecordedItem{
+			ID:          id,
+			ChannelID:   &channelID,
+			Name:        "番組",
+			EndAt:       1787131323000,
+			IsRecording: isRecording,
+			DropLogFile: &EPGStationDropLogFile{ID: id, ErrorCnt: 1, DropCnt: 2, ScramblingCnt: 3},
+		}
+	}
+
+	// 初回の収集では既存の録画済み番組を出力しない
+	plugin.addDropLogMetrics(&accumulator, []*EPGStationRecordedItem{record(1, false)})
+	require.Empty(t, accumulator.metrics)
+
+	// 録画中の番組は録画が終わるまで出力しない
+	plugin.addDropLogMetrics(&accumulator, []*EPGStationRecordedItem{record(1, false), record(2, true)})
+	require.Empty(t, accumulator.metrics)
+
+	// 新しく録画が終わった番組は 1 度だけ出力する
+	plugin.addDropLogMetrics(&accumulator, []*EPGStationRecordedItem{record(1, false), record(2, false)})
+	plugin.addDropLogMetrics(&accumulator, []*EPGStationRecordedItem{record(1, false), record(2, false)})
+
+	metrics := accumulator.metricsOf(dropLogsMeasurement)
+	require.Len(t, metrics, 1)
+	require.Equal(t, map[string]string{"channel_id": "10"}, metrics[0].tags)
+	require.Equal(t, map[string]any{
+		"recorded_id": 2,
+		"name":        "番組",
+		"errors":      int64(1),
+		"drops":       int64(2),
+		"scramblings": int64(3),
+	}, metrics[0].fields)
+	require.Equal(t, time.UnixMilli(1787131323000), metrics[0].timestamp)
+}
+
+func TestPluginGatherDropLogs(t *testing.T) {
+	plugin, server := newTestPlugin(t)
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.Gather(&accumulator))
+
+	server.setResponse("/api/recorded", `{ "records": [
+    { "id": 102, "ruleId": 10, "channelId": 3273601024, "name": "新しい録画", "startAt": 0, "endAt": 1787131323000, "isRecording": false, "isProtected": false,
+      "videoFiles": [], "thumbnails": [], "dropLogFile": { "id": 1, "errorCnt": 0, "dropCnt": 4, "scramblingCnt": 0 } }
+  ], "total": 3 }`)
+	var next testAccumulator
+	require.NoError(t, plugin.Gather(&next))
+
+	// 録画済み番組の統計を無効にしていても、最初のページだけを取得してドロップログを出力する
+	require.Equal(t, 2, server.requestsOf("/api/recorded"))
+	require.Empty(t, next.metricsOf(recordedMeasurement))
+
+	metrics := next.metricsOf(dropLogsMeasurement)
+	require.Len(t, metrics, 1)
+	require.Equal(t, map[string]string{"channel_id": "3273601024", "rule_id": "10"}, metrics[0].tags)
+	require.Equal(t, 102, metrics[0].fields["recorded_id"])
+	require.Equal(t, int64(4), metrics[0].fields["drops"])
+}
+
+func TestGatherEncodeMetrics(t *testing.T) {
+	plugin, server := newTestPlugin(t)
+
+	gather := func(response string) *testAccumulator {
+		server.setResponse("/api/encode", response)
+
+		var accumulator testAccumulator
+		require.NoError(t, plugin.gatherEncodeMetrics(t.Context(), &accumulator))
+		return &accumulator
+	}
+
+	// 初回の収集より前からキューにあったエンコードは、完了しても所要時間が分からないため出力しない
+	gather(`{ "runningItems": [{ "id": 1, "mode": "H.264", "recorded": { "id": 100, "name": "番組" }, "percent": 0.5 }], "waitItems": [] }`)
+	accumulator := gather(`{ "runningItems": [{ "id": 2, "mode": "H.265", "recorded": { "id": 101, "name": "番組" } }], "waitItems": [] }`)
+	require.Empty(t, accumulator.metricsOf(encodeCompletionMeasurement))
+
+	encodes := accumulator.metricsOf(encodesMeasurement)
+	require.Len(t, encodes, 1)
+	require.Equal(t, map[string]string{"mode": "H.265", "status": "running"}, encodes[0].tags)
+	require.Equal(t, 2, encodes[0].fields["encode_id"])
+	require.Equal(t, 101, encodes[0].fields["recorded_id"])
+
+	// キューから消えたエンコードは完了したとみなす
+	accumulator = gather(`{ "runningItems": [], "waitItems": [] }`)
+	completions := accumulator.metricsOf(encodeCompletionMeasurement)
+	require.Len(t, completions, 1)
+	require.Equal(t, map[string]string{"mode": "H.265"}, completions[0].tags)
+	require.Equal(t, 2, completions[0].fields["encode_id"])
+	require.Equal(t, 101, completions[0].fields["recorded_id"])
+
+	// 1 度出力した完了は再度出力しない
+	accumulator = gather(`{ "runningItems": [], "waitItems": [] }`)
+	require.Empty(t, accumulator.metricsOf(encodeCompletionMeasurement))
+}
+
+func TestGatherStoragesMetrics(t *testing.T) {
+	plugin, _ := newTestPlugin(t)
+
+	// 1 時間前の使用量が 1000 バイトで、現在は 1500 バイト
+	plugin.storageSamples = map[string][]storageSample{
+		"recorded": {{at: time.Now().Add(-time.Hour), used: 1000}},
+	}
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.gatherStoragesMetrics(t.Context(), &accumulator, plugin.newGatherSources(t.Context())))
+
+	metrics := accumulator.metricsOf(measurement)
+	require.Len(t, metrics, 1)
+	require.Equal(t, map[string]string{"storage_name": "recorded"}, metrics[0].tags)
+	require.InDelta(t, 500, metrics[0].fields["storage_fill_rate_bytes_per_hour"], 1)
+	require.InDelta(t, 18, metrics[0].fields["storage_hours_until_full"], 0.1)
+}
+
+func TestGatherStoragesMetricsWithoutGrowth(t *testing.T) {
+	plugin, _ := newTestPlugin(t)
+
+	// 期間外の古い記録は使わず、使用量が増えていなければ満杯になる時刻を見積もらない
+	plugin.storageSamples = map[string][]storageSample{
+		"recorded": {
+			{at: time.Now().Add(-7 * time.Hour), used: 0},
+			{at: time.Now().Add(-time.Hour), used: 1500},
+		},
+	}
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.gatherStoragesMetrics(t.Context(), &accumulator, plugin.newGatherSources(t.Context())))
+
+	metrics := accumulator.metricsOf(measurement)
+	require.Len(t, metrics, 1)
+	require.InDelta(t, 0, metrics[0].fields["storage_fill_rate_bytes_per_hour"], 0.001)
+	require.NotContains(t, metrics[0].fields, "storage_hours_until_full")
+	require.Len(t, plugin.storageSamples["recorded"], 2)
+}
+
+func TestEstimateUpcomingReservesBytes(t *testing.T) {
+	plugin := &Plugin{
+		EPGStationReservesWindow:   24 * time.Hour,
+		EPGStationRecordingBitrate: 8000,
+	}
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.Local)
+	other := "other"
+	reserve := func(start, end time.Duration, directory *string, isSkip bool) *EPGStationReserve {
+		return &EPGStationReserve{
+			StartAt:             now.Add(start).UnixMilli(),
+			EndAt:               now.Add(end).UnixMilli(),
+			IsSkip:              isSkip,
+			ParentDirectoryName: directory,
+		}
+	}
+	storages := &EPGStationStorages{}
+	require.NoError(t, json.Unmarshal([]byte(`{ "items": [{ "name": "recorded" }, { "name": "other" }] }`), storages))
+
+	results := plugin.estimateUpcomingReservesBytes([]*EPGStationReserve{
+		// 録画中の予約は残りの 10 分のみを見積もる
+		reserve(-10*time.Minute, 10*time.Minute, nil, false),
+		reserve(time.Hour, 2*time.Hour, &other, false),
+		// 録画されない予約、終了した予約、期間より先の予約は対象外
+		reserve(time.Hour, 2*time.Hour, nil, true),
+		reserve(-2*time.Hour, -time.Hour, nil, false),
+		reserve(25*time.Hour, 26*time.Hour, nil, false),
+	}, storages, now)
+
+	require.Equal(t, map[string]int64{"recorded": 600 * 1000, "other": 3600 * 1000}, results)
+}
+
+func TestGatherStoragesMetricsWithoutReserves(t *testing.T) {
+	plugin, server := newTestPlugin(t)
+	delete(server.responses, "/api/reserves")
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.gatherStoragesMetrics(t.Context(), &accumulator, plugin.newGatherSources(t.Context())))
+
+	// 予約を取得できなくても容量は出力する
+	metrics := accumulator.metricsOf(measurement)
+	require.Len(t, metrics, 1)
+	require.Equal(t, 10500, metrics[0].fields["storage_total"])
+	require.Equal(t, 1500, metrics[0].fields["storage_used"])
+	require.Equal(t, 9000, metrics[0].fields["storage_available"])
+	require.NotContains(t, metrics[0].fields, "storage_upcoming_reserves_bytes")
+}
+
+func TestInitValidatesStorageForecastWindow(t *testing.T) {
+	t.Setenv("EPGSTATION_STORAGE_FORECAST_WINDOW", "-1h")
+
+	require.Error(t, (&Plugin{}).Init())
+}
+
+func TestGatherReservesMetrics(t *testing.T) {
+	t.Setenv("EPGSTATION_RESERVES_WINDOW", "2h")
+	plugin, server := newTestPlugin(t)
+
+	now := time.Now()
+	at := func(d time.Duration) int64 { return now.Add(d).UnixMilli() }
+	server.setResponse("/api/reserves", fmt.Sprintf(`{ "reserves": [
+    { "id": 1, "ruleId": 10, "channelId": 3273601024, "name": "予約", "startAt": %d, "endAt": %d, "isSkip": false, "isConflict": true, "isOverlap": false },
+    { "id": 2, "channelId": 3273601025, "name": "録画中の手動予約", "startAt": %d, "endAt": %d, "isSkip": true, "isConflict": false, "isOverlap": true },
+    { "id": 3, "ruleId": 10, "channelId": 3273601024, "name": "期間外", "startAt": %d, "endAt": %d, "isSkip": false, "isConflict": false, "isOverlap": false },
+    { "id": 4, "ruleId": 10, "channelId": 3273601024, "name": "終了済み", "startAt": %d, "endAt": %d, "isSkip": false, "isConflict": false, "isOverlap": false }
+  ], "total": 4 }`,
+		at(time.Hour+30*time.Second), at(time.Hour+30*time.Minute+30*time.Second),
+		at(-10*time.Minute-30*time.Second), at(20*time.Minute),
+		at(3*time.Hour), at(4*time.Hour),
+		at(-2*time.Hour), at(-time.Hour),
+	))
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.gatherReservesMetrics(t.Context(), &accumulator, plugin.newGatherSources(t.Context())))
+
+	// 終了した予約と EPGSTATION_RESERVES_WINDOW より先に開始する予約は出力しない
+	metrics := accumulator.metricsOf(reservesMeasurement)
+	require.Len(t, metrics, 2)
+
+	require.Equal(t, map[string]string{"channel_id": "3273601024", "rule_id": "10"}, metrics[0].tags)
+	require.Equal(t, map[string]any{
+		"reserve_id":          1,
+		"name":                "予約",
+		"minutes_until_start": int64(60),
+		"duration_minutes":    int64(30),
+		"is_conflict":         true,
+		"is_skip":             false,
+		"is_overlap":          false,
+	}, metrics[0].fields)
+
+	// 手動予約には rule_id タグを付けない
+	require.Equal(t, map[string]string{"channel_id": "3273601025"}, metrics[1].tags)
+	require.Equal(t, map[string]any{
+		"reserve_id":          2,
+		"name":                "録画中の手動予約",
+		"minutes_until_start": int64(-10),
+		"duration_minutes":    int64(30),
+		"is_conflict":         false,
+		"is_skip":             true,
+		"is_overlap":          true,
+	}, metrics[1].fields)
+}
+
+func TestGatherRecordedMetrics(t *testing.T) {
+	plugin, server := newTestPlugin(t)
+	server.setResponse("/api/recorded", `{ "records": [
+    { "id": 1, "ruleId": 10, "channelId": 100, "name": "A", "startAt": 0, "endAt": 1, "isRecording": true, "isProtected": false,
+      "videoFiles": [{ "id": 1, "name": "TS", "type": "ts", "size": 1000 }], "thumbnails": [],
+      "dropLogFile": { "id": 1, "errorCnt": 1, "dropCnt": 2, "scramblingCnt": 3 } },
+    { "id": 2, "ruleId": 10, "channelId": 100, "name": "B", "startAt": 0, "endAt": 1, "isRecording": false, "isProtected": true,
+      "videoFiles": [{ "id": 2, "name": "TS", "type": "ts", "size": 2000 }, { "id": 3, "name": "H.264", "type": "encoded", "size": 500 }], "thumbnails": [2],
+      "dropLogFile": { "id": 2, "errorCnt": 10, "dropCnt": 20, "scramblingCnt": 30 } },
+    { "id": 3, "channelId": 100, "name": "C", "startAt": 0, "endAt": 1, "isRecording": false, "isProtected": false,
+      "videoFiles": [{ "id": 4, "name": "H.265", "type": "encoded", "size": 700 }], "thumbnails": [3] },
+    { "id": 4, "ruleId": 11, "channelId": 200, "name": "D", "startAt": 0, "endAt": 1, "isRecording": false, "isProtected": false,
+      "videoFiles": [], "thumbnails": [] }
+  ], "total": 4 }`)
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.gatherRecordedMetrics(t.Context(), &accumulator, plugin.newGatherSources(t.Context())))
+
+	// チャンネルとルールの組み合わせごとに集計し、手動録画には rule_id タグを付けない
+	metrics := make(map[string]map[string]any)
+	for _, metric := range accumulator.metricsOf(recordedMeasurement) {
+		metrics[metric.tags["channel_id"]+"/"+metric.tags["rule_id"]] = metric.fields
+	}
+	require.Equal(t, map[string]map[string]any{
+		"100/10": {
+			"recorded":             2,
+			"recording":            1,
+			"protected":            1,
+			"ts_bytes":             int64(3000),
+			"encoded_bytes":        int64(500),
+			"drop_log_errors":      int64(11),
+			"drop_log_drops":       int64(22),
+			"drop_log_scramblings": int64(33),
+		},
+		"100/": {
+			"recorded":             1,
+			"recording":            0,
+			"protected":            0,
+			"ts_bytes":             int64(0),
+			"encoded_bytes":        int64(700),
+			"drop_log_errors":      int64(0),
+			"drop_log_drops":       int64(0),
+			"drop_log_scramblings": int64(0),
+		},
+		"200/11": {
+			"recorded":             1,
+			"recording":            0,
+			"protected":            0,
+			"ts_bytes":             int64(0),
+			"encoded_bytes":        int64(0),
+			"drop_log_errors":      int64(0),
+			"drop_log_drops":       int64(0),
+			"drop_log_scramblings": int64(0),
+		},
+	}, metrics)
+}
+
+func TestPluginGatherWithoutRecorded(t *testing.T) {
+	plugin, server := newTestPlugin(t)
+	// ライブラリ全体をページングすると複数回のリクエストになる件数
+	server.setResponse("/api/recorded", strings.Replace(defaultResponses["/api/recorded"], `"total": 2`, `"total": 1000`, 1))
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.Gather(&accumulator))
+
+	// 明示的に有効化しない限り、録画済み番組のライブラリ全体は取得せず、ドロップログ用に最初のページのみを取得する
+	require.Equal(t, 1, server.requestsOf("/api/recorded"))
+	require.Empty(t, accumulator.metricsOf(recordedMeasurement))
+
+	rules := accumulator.metricsOf(rulesMeasurement)
+	require.Len(t, rules, 1)
+	require.NotContains(t, rules[0].fields, "recorded")
+}
+
+func TestPluginAuthentication(t *testing.T) {
+	plugin, server := newTestPlugin(t)
+	plugin.Username = "user"
+	plugin.Password = "pass"
+	plugin.Headers = map[string]string{"X-Api-Key": "key"}
+	require.NoError(t, plugin.Init())
+
+	_, err := plugin.client.GetStorages(t.Context())
+	require.NoError(t, err)
+
+	// リバースプロキシの背後の EPGStation 向けに、Basic 認証と任意のヘッダーを付与する
+	header := server.lastHeader()
+	require.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("user:pass")), header.Get("Authorization"))
+	require.Equal(t, "key", header.Get("X-Api-Key"))
+}
+
+func TestPluginTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(defaultResponses["/api/storages"]))
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("EPGSTATION_BASE_URL", server.URL)
+
+	// 自己署名証明書は検証に失敗する
+	plugin := &Plugin{}
+	require.NoError(t, plugin.Init())
+	_, err := plugin.client.GetStorages(t.Context())
+	require.ErrorAs(t, err, new(*EPGStationUnreachableError))
+
+	plugin = &Plugin{}
+	plugin.InsecureSkipVerify = true
+	require.NoError(t, plugin.Init())
+	_, err = plugin.client.GetStorages(t.Context())
+	require.NoError(t, err)
+}
+
+func TestPluginTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-release
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+
+	t.Setenv("EPGSTATION_BASE_URL", server.URL)
+	t.Setenv("EPGSTATION_TIMEOUT", "50ms")
+
+	plugin := &Plugin{}
+	require.NoError(t, plugin.Init())
+
+	_, err := plugin.client.GetStorages(t.Context())
+	require.ErrorAs(t, err, new(*EPGStationUnreachableError))
+}
+
+// newTestPluginWithHandler は全てのエンドポイントに同じ応答を返す EPGStation に接続するプラグインを作る
+func newTestPluginWithHandler(t *testing.T, handler http.HandlerFunc) *Plugin {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	t.Setenv("EPGSTATION_BASE_URL", server.URL)
+
+	plugin := &Plugin{}
+	require.NoError(t, plugin.Init())
+	return plugin
+}
+
+func TestPluginGatherUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	t.Setenv("EPGSTATION_BASE_URL", server.URL)
+	plugin := &Plugin{}
+	require.NoError(t, plugin.Init())
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.Gather(&accumulator))
+
+	up := accumulator.metricsOf(upMeasurement)
+	require.Len(t, up, 1)
+	require.Equal(t, map[string]any{"up": false}, up[0].fields)
+
+	endpoints := accumulator.metricsOf(endpointsMeasurement)
+	require.NotEmpty(t, endpoints)
+	for _, metric := range endpoints {
+		require.Equal(t, false, metric.fields["ok"])
+		require.Equal(t, "unreachable", metric.fields["error_type"])
+	}
+}
+
+func TestPluginGatherProxyError(t *testing.T) {
+	// EPGStation が停止している間、リバースプロキシは HTML のエラーページを返す
+	plugin := newTestPluginWithHandler(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte("<html><body><h1>502 Bad Gateway</h1></body></html>"))
+	})
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.Gather(&accumulator))
+
+	up := accumulator.metricsOf(upMeasurement)
+	require.Len(t, up, 1)
+	require.Equal(t, map[string]any{"up": false}, up[0].fields)
+
+	endpoints := accumulator.metricsOf(endpointsMeasurement)
+	require.NotEmpty(t, endpoints)
+	for _, metric := range endpoints {
+		require.Equal(t, false, metric.fields["ok"])
+		require.Equal(t, "http", metric.fields["error_type"])
+		require.Contains(t, metric.fields["last_error"], "unexpected status code: 502")
+	}
+}
+
+func TestPluginGatherAPIError(t *testing.T) {
+	// EPGStation 自身のエラーは JSON で返される
+	plugin := newTestPluginWithHandler(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{ "code": 500, "message": "Internal Server Error", "errors": "database is locked" }`))
+	})
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.Gather(&accumulator))
+
+	// EPGStation は応答しているため稼働しているとみなす
+	up := accumulator.metricsOf(upMeasurement)
+	require.Len(t, up, 1)
+	require.Equal(t, map[string]any{"up": true}, up[0].fields)
+
+	endpoints := accumulator.metricsOf(endpointsMeasurement)
+	require.NotEmpty(t, endpoints)
+	for _, metric := range endpoints {
+		require.Equal(t, false, metric.fields["ok"])
+		require.Equal(t, "api", metric.fields["error_type"])
+		require.Contains(t, metric.fields["last_error"], "api error: 500: Internal Server Error, database is locked")
+	}
+}
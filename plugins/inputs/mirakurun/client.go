@@ -1,11 +1,16 @@
 package mirakurun
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
+	"net/url"
 )
 
 type MirakurunClient struct {
@@ -19,6 +24,9 @@ func NewMirakurunClient(baseURL string) *MirakurunClient {
 }
 
 type MirakurunStatus struct {
+	// time はミリ秒単位
+	Time          *int64                        `json:"time"`
+	Version       string                        `json:"version"`
 	Process       *MirakurunStatusProcess       `json:"process"`
 	EPG           *MirakurunStatusEPG           `json:"epg"`
 	RPCCount      *int                          `json:"rpcCount"`
@@ -28,6 +36,9 @@ type MirakurunStatus struct {
 }
 
 type MirakurunStatusProcess struct {
+	Arch        string                      `json:"arch"`
+	Platform    string                      `json:"platform"`
+	PID         *int                        `json:"pid"`
 	MemoryUsage *MirakurunStatusMemoryUsage `json:"memoryUsage"`
 }
 
@@ -81,8 +92,10 @@ func (c *MirakurunClient) GetStatus(ctx context.Context) (*MirakurunStatus, erro
 }
 
 type MirakurunChannel struct {
-	Type     string      `json:"type"`
-	Services []*struct{} `json:"services"`
+	Type     string              `json:"type"`
+	Channel  string              `json:"channel"`
+	Name     string              `json:"name"`
+	Services []*MirakurunService `json:"services"`
 }
 
 func (c *MirakurunClient) GetChannels(ctx context.Context) ([]*MirakurunChannel, error) {
@@ -94,12 +107,111 @@ func (c *MirakurunClient) GetChannels(ctx context.Context) ([]*MirakurunChannel,
 	return results, nil
 }
 
+type MirakurunService struct {
+	ID                int    `json:"id"`
+	ServiceID         int    `json:"serviceId"`
+	NetworkID         int    `json:"networkId"`
+	TransportStreamID int    `json:"transportStreamId"`
+	Name              string `json:"name"`
+	Type              int    `json:"type"`
+	// 以下は /api/services でのみ返され、/api/channels に含まれる services では欠落する
+	RemoteControlKeyID *int                     `json:"remoteControlKeyId"`
+	HasLogoData        bool                     `json:"hasLogoData"`
+	EPGReady           bool                     `json:"epgReady"`
+	EPGUpdatedAt       *int64                   `json:"epgUpdatedAt"`
+	Channel            *MirakurunServiceChannel `json:"channel"`
+}
+
+type MirakurunServiceChannel struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+}
+
+func (c *MirakurunClient) GetServices(ctx context.Context) ([]*MirakurunService, error) {
+	var results []*MirakurunService
+	if err := c.get(ctx, "/api/services", &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+type MirakurunProgram struct {
+	ID        int `json:"id"`
+	EventID   int `json:"eventId"`
+	ServiceID int `json:"serviceId"`
+	NetworkID int `json:"networkId"`
+	// startAt と duration はミリ秒単位
+	StartAt  int64 `json:"startAt"`
+	Duration int64 `json:"duration"`
+}
+
+func (c *MirakurunClient) GetPrograms(ctx context.Context) ([]*MirakurunProgram, error) {
+	var results []*MirakurunProgram
+	if err := c.get(ctx, "/api/programs", &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+type MirakurunConfigTuner struct {
+	Name       string   `json:"name"`
+	Types      []string `json:"types"`
+	IsDisabled bool     `json:"isDisabled"`
+}
+
+type MirakurunConfigChannel struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Channel    string `json:"channel"`
+	IsDisabled bool   `json:"isDisabled"`
+}
+
+// GetConfig は /api/config/{name} の設定を加工せずに返す
+func (c *MirakurunClient) GetConfig(ctx context.Context, name string) (json.RawMessage, error) {
+	var result json.RawMessage
+	if err := c.get(ctx, "/api/config/"+name, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
 type MirakurunTuner struct {
-	IsAvailable bool `json:"isAvailable"`
-	IsRemote    bool `json:"isRemote"`
-	IsFree      bool `json:"isFree"`
-	IsUsing     bool `json:"isUsing"`
-	IsFault     bool `json:"isFault"`
+	Index   int      `json:"index"`
+	Name    string   `json:"name"`
+	Types   []string `json:"types"`
+	Command string   `json:"command"`
+	// プロセスが起動していないチューナーでは pid が返されない
+	PID         *int                  `json:"pid"`
+	Users       []*MirakurunTunerUser `json:"users"`
+	IsAvailable bool                  `json:"isAvailable"`
+	IsRemote    bool                  `json:"isRemote"`
+	IsFree      bool                  `json:"isFree"`
+	IsUsing     bool                  `json:"isUsing"`
+	IsFault     bool                  `json:"isFault"`
+}
+
+type MirakurunTunerUser struct {
+	ID             string                           `json:"id"`
+	Priority       int                              `json:"priority"`
+	Agent          string                           `json:"agent"`
+	DisableDecoder bool                             `json:"disableDecoder"`
+	StreamSetting  *MirakurunTunerUserStreamSetting `json:"streamSetting"`
+	// PID ごとのパケット数とドロップ数。Mahiron などの互換実装は返さない
+	StreamInfo map[string]*MirakurunTunerUserStreamInfo `json:"streamInfo"`
+}
+
+type MirakurunTunerUserStreamSetting struct {
+	Channel   *MirakurunServiceChannel `json:"channel"`
+	NetworkID *int                     `json:"networkId"`
+	ServiceID *int                     `json:"serviceId"`
+}
+
+type MirakurunTunerUserStreamInfo struct {
+	Packet int64 `json:"packet"`
+	Drop   int64 `json:"drop"`
 }
 
 func (c *MirakurunClient) GetTuners(ctx context.Context) ([]*MirakurunTuner, error) {
@@ -111,21 +223,90 @@ func (c *MirakurunClient) GetTuners(ctx context.Context) ([]*MirakurunTuner, err
 	return results, nil
 }
 
-func (c *MirakurunClient) get(ctx context.Context, path string, result any) error {
-	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+type MirakurunEvent struct {
+	Resource string          `json:"resource"`
+	Type     string          `json:"type"`
+	Data     json.RawMessage `json:"data"`
+	// time はミリ秒単位
+	Time int64 `json:"time"`
+}
+
+// StreamEvents は /api/events/stream を購読し、受信したイベントごとに handler を呼び出す
+// ストリームが切断されるか ctx がキャンセルされるまで返らない
+func (c *MirakurunClient) StreamEvents(ctx context.Context, handler func(*MirakurunEvent)) error {
+	// レスポンスは終わりのない JSON 配列であり、"[" の後にイベントと "," が 1 行ずつ交互に送られてくる
+	return c.stream(ctx, "/api/events/stream", func(line []byte) error {
+		line = bytes.TrimSpace(line)
+		line = bytes.TrimPrefix(line, []byte(","))
+		line = bytes.TrimSuffix(line, []byte(","))
+		if len(line) == 0 || bytes.Equal(line, []byte("[")) || bytes.Equal(line, []byte("]")) {
+			return nil
+		}
+
+		var event MirakurunEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+
+		handler(&event)
+		return nil
+	})
+}
+
+// StreamLog は /api/log/stream を購読し、受信したログ 1 行ごとに handler を呼び出す
+// ストリームが切断されるか ctx がキャンセルされるまで返らない
+func (c *MirakurunClient) StreamLog(ctx context.Context, handler func(string)) error {
+	return c.stream(ctx, "/api/log/stream", func(line []byte) error {
+		if len(bytes.TrimSpace(line)) == 0 {
+			return nil
+		}
+
+		handler(string(line))
+		return nil
+	})
+}
+
+func (c *MirakurunClient) stream(ctx context.Context, path string, handler func([]byte) error) error {
+	response, err := c.do(ctx, path, nil)
 	if err != nil {
 		return err
 	}
 
-	request.Header.Set("User-Agent", "telegraf-input-mirakurun (+https://github.com/SlashNephy/telegraf-plugins)")
+	defer func() { _ = response.Body.Close() }()
 
-	response, err := http.DefaultClient.Do(request)
-	if err != nil {
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if err = handler(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
 		return err
 	}
 
-	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", response.StatusCode)
+	return errors.New("stream closed")
+}
+
+// StreamChannel は指定したチャンネルの MPEG-TS ストリームを開く
+// 録画や視聴を妨げないよう、チューナーの横取りが起きない最低の優先度で要求する
+func (c *MirakurunClient) StreamChannel(ctx context.Context, channelType, channel string) (io.ReadCloser, error) {
+	path := fmt.Sprintf("/api/channels/%s/%s/stream?decode=1", url.PathEscape(channelType), url.PathEscape(channel))
+	response, err := c.do(ctx, path, http.Header{
+		"X-Mirakurun-Priority": []string{"-1"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Body, nil
+}
+
+func (c *MirakurunClient) get(ctx context.Context, path string, result any) error {
+	response, err := c.do(ctx, path, nil)
+	if err != nil {
+		return err
 	}
 
 	defer func() { _ = response.Body.Close() }()
@@ -141,3 +322,25 @@ func (c *MirakurunClient) get(ctx context.Context, path string, result any) erro
 
 	return nil
 }
+
+func (c *MirakurunClient) do(ctx context.Context, path string, header http.Header) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	maps.Copy(request.Header, header)
+	request.Header.Set("User-Agent", "telegraf-input-mirakurun (+https://github.com/SlashNephy/telegraf-plugins)")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		_ = response.Body.Close()
+		return nil, fmt.Errorf("unexpected status code: %d", response.StatusCode)
+	}
+
+	return response, nil
+}
@@ -0,0 +1,85 @@
+package mirakurun
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/influxdata/telegraf"
+	"github.com/samber/lo"
+)
+
+const (
+	configMeasurement        = "mirakurun_config"
+	configChangedMeasurement = "mirakurun_config_changed"
+)
+
+// 変更を検出する設定ファイル
+var configDocuments = []string{"server", "tuners", "channels"}
+
+// gatherConfigMetrics は設定ファイルのハッシュ値と設定内容の件数を記録し、前回の収集から設定が変更されていれば変更を出力する
+func (i *instance) gatherConfigMetrics(ctx context.Context, accumulator telegraf.Accumulator) error {
+	documents := make(map[string]json.RawMessage, len(configDocuments))
+	for _, name := range configDocuments {
+		document, err := i.client.GetConfig(ctx, name)
+		if err != nil {
+			return fmt.Errorf("failed to get %s config: %w", name, err)
+		}
+
+		documents[name] = document
+	}
+
+	var (
+		configTuners   []*MirakurunConfigTuner
+		configChannels []*MirakurunConfigChannel
+	)
+	if err := json.Unmarshal(documents["tuners"], &configTuners); err != nil {
+		return fmt.Errorf("failed to decode tuners config: %w", err)
+	}
+	if err := json.Unmarshal(documents["channels"], &configChannels); err != nil {
+		return fmt.Errorf("failed to decode channels config: %w", err)
+	}
+
+	// 設定されたチューナーのうち、実際に認識されているチューナーの数と比較する
+	tuners, err := i.client.GetTuners(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get tuners: %w", err)
+	}
+
+	fields := map[string]any{
+		"tuners_configured": len(configTuners),
+		"tuners_disabled":   lo.CountBy(configTuners, func(t *MirakurunConfigTuner) bool { return t.IsDisabled }),
+		"tuners_reported":   len(tuners),
+		"channels_enabled":  lo.CountBy(configChannels, func(c *MirakurunConfigChannel) bool { return !c.IsDisabled }),
+		"channels_disabled": lo.CountBy(configChannels, func(c *MirakurunConfigChannel) bool { return c.IsDisabled }),
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.lastConfigHashes == nil {
+		i.lastConfigHashes = make(map[string]string, len(configDocuments))
+	}
+
+	for _, name := range configDocuments {
+		sum := sha256.Sum256(documents[name])
+		hash := hex.EncodeToString(sum[:])
+		fields[name+"_hash"] = hash
+
+		// 初回の収集では比較対象がないため、変更として扱わない
+		if previous, ok := i.lastConfigHashes[name]; ok && previous != hash {
+			accumulator.AddFields(configChangedMeasurement, map[string]any{
+				"previous_hash": previous,
+				"hash":          hash,
+			}, map[string]string{
+				"document": name,
+			})
+		}
+		i.lastConfigHashes[name] = hash
+	}
+
+	accumulator.AddFields(configMeasurement, fields, nil)
+	return nil
+}
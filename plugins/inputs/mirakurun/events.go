@@ -0,0 +1,97 @@
+package mirakurun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+const eventsMeasurement = "mirakurun_events"
+
+type eventCountKey struct {
+	resource  string
+	eventType string
+}
+
+type tunerState struct {
+	isAvailable bool
+	isFree      bool
+	isUsing     bool
+	isFault     bool
+}
+
+// eventsListener は /api/events/stream を購読し、イベントの受信数とチューナーの状態を保持する
+type eventsListener struct {
+	client *MirakurunClient
+
+	mu          sync.Mutex
+	counts      map[eventCountKey]int64
+	tunerStates map[int]tunerState
+}
+
+func newEventsListener(client *MirakurunClient) *eventsListener {
+	return &eventsListener{
+		client:      client,
+		counts:      make(map[eventCountKey]int64),
+		tunerStates: make(map[int]tunerState),
+	}
+}
+
+func (l *eventsListener) name() string {
+	return "event stream"
+}
+
+func (l *eventsListener) listen(ctx context.Context, accumulator telegraf.Accumulator) error {
+	return l.client.StreamEvents(ctx, func(event *MirakurunEvent) {
+		l.handle(accumulator, event)
+	})
+}
+
+func (l *eventsListener) handle(accumulator telegraf.Accumulator, event *MirakurunEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.counts[eventCountKey{resource: event.Resource, eventType: event.Type}]++
+
+	if event.Resource != "tuner" {
+		return
+	}
+
+	var tuner MirakurunTuner
+	if err := json.Unmarshal(event.Data, &tuner); err != nil {
+		accumulator.AddError(fmt.Errorf("failed to decode tuner event: %w", err))
+		return
+	}
+
+	// ポーリング間隔の間に起きた短時間の異常も記録できるよう、状態が変化した時点で出力する
+	state := tunerState{
+		isAvailable: tuner.IsAvailable,
+		isFree:      tuner.IsFree,
+		isUsing:     tuner.IsUsing,
+		isFault:     tuner.IsFault,
+	}
+	if previous, ok := l.tunerStates[tuner.Index]; ok && previous == state {
+		return
+	}
+	l.tunerStates[tuner.Index] = state
+
+	accumulator.AddFields(tunersMeasurement, tunerFields(&tuner), tunerTags(&tuner), time.UnixMilli(event.Time))
+}
+
+func (l *eventsListener) gather(accumulator telegraf.Accumulator) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, count := range l.counts {
+		accumulator.AddFields(eventsMeasurement, map[string]any{
+			"count": count,
+		}, map[string]string{
+			"resource": key.resource,
+			"type":     key.eventType,
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package mirakurun
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"golang.org/x/sync/errgroup"
+)
+
+// MIRAKURUN_BASE_URL のみで設定された場合のインスタンス名
+const defaultInstanceName = "default"
+
+type Instance struct {
+	Name    string `toml:"name"`
+	BaseURL string `toml:"base_url"`
+}
+
+// instance は監視対象の Mirakurun ごとにクライアントと収集対象を保持する
+type instance struct {
+	name        string
+	client      *MirakurunClient
+	gatherFuncs []*gatherFunc
+	listeners   []streamListener
+
+	mu sync.Mutex
+	// 直近に観測したプロセスの PID と、その PID を初めて観測した時刻 (Mirakurun の時計でミリ秒単位)
+	lastPID         *int
+	lastPIDSeenAt   int64
+	processRestarts int64
+	// 前回の収集時にチューナーを使用していたユーザーと、チューナーごとの優先度による横取りの累計回数
+	lastTunerUsers map[int][]*MirakurunTunerUser
+	preemptions    map[int]int64
+	// 前回の収集時に取得した設定ファイルごとのハッシュ値
+	lastConfigHashes map[string]string
+	// エンドポイントごとの直近のエラー
+	lastErrors map[string]string
+}
+
+// gatherFunc は収集に使うエンドポイントの名前と収集処理の組
+type gatherFunc struct {
+	endpoint string
+	f        func(context.Context, telegraf.Accumulator) error
+}
+
+// accumulator はこのインスタンスから収集したメトリクスであることを示す instance タグを付与する
+func (i *instance) accumulator(accumulator telegraf.Accumulator) telegraf.Accumulator {
+	return &instanceAccumulator{
+		Accumulator: accumulator,
+		instance:    i.name,
+	}
+}
+
+// gather はすべての収集処理を実行し、エンドポイントごとのエラーを返す
+func (i *instance) gather(ctx context.Context, accumulator telegraf.Accumulator) map[string]error {
+	var (
+		eg   errgroup.Group
+		mu   sync.Mutex
+		errs = make(map[string]error, len(i.gatherFuncs))
+	)
+	for _, g := range i.gatherFuncs {
+		eg.Go(func() error {
+			err := g.call(ctx, accumulator)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			errs[g.endpoint] = err
+			return nil
+		})
+	}
+	_ = eg.Wait()
+
+	return errs
+}
+
+func (g *gatherFunc) call(ctx context.Context, accumulator telegraf.Accumulator) (err error) {
+	// 未知のレスポンス形状で panic しても execd プロセス全体を落とさない
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while gathering metrics: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	return g.f(ctx, accumulator)
+}
+
+// addEndpointMetrics はエンドポイントごとの収集の成否と直近のエラーを出力する
+func (i *instance) addEndpointMetrics(accumulator telegraf.Accumulator, errs map[string]error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.lastErrors == nil {
+		i.lastErrors = make(map[string]string, len(i.gatherFuncs))
+	}
+
+	for _, g := range i.gatherFuncs {
+		err := errs[g.endpoint]
+		if err != nil {
+			accumulator.AddError(fmt.Errorf("failed to gather %s metrics: %w", g.endpoint, err))
+			i.lastErrors[g.endpoint] = err.Error()
+		}
+
+		fields := map[string]any{
+			"ok": err == nil,
+		}
+		if lastError, ok := i.lastErrors[g.endpoint]; ok {
+			fields["last_error"] = lastError
+		}
+
+		accumulator.AddFields(endpointsMeasurement, fields, map[string]string{
+			"endpoint": g.endpoint,
+		})
+	}
+}
+
+type instanceAccumulator struct {
+	telegraf.Accumulator
+
+	instance string
+}
+
+func (a *instanceAccumulator) AddFields(measurement string, fields map[string]any, tags map[string]string, t ...time.Time) {
+	merged := make(map[string]string, len(tags)+1)
+	maps.Copy(merged, tags)
+	merged["instance"] = a.instance
+
+	a.Accumulator.AddFields(measurement, fields, merged, t...)
+}
+
+func (a *instanceAccumulator) AddError(err error) {
+	a.Accumulator.AddError(fmt.Errorf("%s: %w", a.instance, err))
+}
@@ -0,0 +1,123 @@
+package mirakurun
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+const (
+	logMeasurement       = "mirakurun_log"
+	logErrorsMeasurement = "mirakurun_log_errors"
+)
+
+var (
+	// Mirakurun のログは "<ISO 8601 形式の時刻> <level>: <message>" の形式で出力される
+	logLinePattern = regexp.MustCompile(`^(\S+) (debug|info|warning|error|fatal): (.*)$`)
+	// メッセージの先頭が "TunerDevice#0" のようなクラス名で始まる場合、それをコンポーネントとみなす
+	logComponentPattern = regexp.MustCompile(`^([A-Z][A-Za-z]*)(?:#\S*)?[\s:]`)
+)
+
+type logCountKey struct {
+	level     string
+	component string
+}
+
+type logLine struct {
+	time      time.Time
+	level     string
+	component string
+	message   string
+}
+
+// parseLogLine はログ 1 行から level とコンポーネントを取り出す
+// スタックトレースの継続行など、形式に合致しない行では false を返す
+func parseLogLine(line string) (*logLine, bool) {
+	matches := logLinePattern.FindStringSubmatch(line)
+	if matches == nil {
+		return nil, false
+	}
+
+	parsed := &logLine{
+		level:     matches[2],
+		component: "other",
+		message:   matches[3],
+	}
+	if t, err := time.Parse(time.RFC3339Nano, matches[1]); err == nil {
+		parsed.time = t
+	}
+	if component := logComponentPattern.FindStringSubmatch(parsed.message); component != nil {
+		parsed.component = component[1]
+	}
+
+	return parsed, true
+}
+
+// logListener は /api/log/stream を購読し、level とコンポーネントごとのログ行数を保持する
+type logListener struct {
+	client *MirakurunClient
+
+	mu     sync.Mutex
+	counts map[logCountKey]int64
+}
+
+func newLogListener(client *MirakurunClient) *logListener {
+	return &logListener{
+		client: client,
+		counts: make(map[logCountKey]int64),
+	}
+}
+
+func (l *logListener) name() string {
+	return "log stream"
+}
+
+func (l *logListener) listen(ctx context.Context, accumulator telegraf.Accumulator) error {
+	return l.client.StreamLog(ctx, func(line string) {
+		l.handle(accumulator, line)
+	})
+}
+
+func (l *logListener) handle(accumulator telegraf.Accumulator, line string) {
+	parsed, ok := parseLogLine(line)
+	if !ok {
+		return
+	}
+
+	l.mu.Lock()
+	l.counts[logCountKey{level: parsed.level, component: parsed.component}]++
+	l.mu.Unlock()
+
+	if parsed.level != "error" && parsed.level != "fatal" {
+		return
+	}
+
+	var t []time.Time
+	if !parsed.time.IsZero() {
+		t = append(t, parsed.time)
+	}
+
+	accumulator.AddFields(logErrorsMeasurement, map[string]any{
+		"message": parsed.message,
+	}, map[string]string{
+		"level":     parsed.level,
+		"component": parsed.component,
+	}, t...)
+}
+
+func (l *logListener) gather(accumulator telegraf.Accumulator) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, count := range l.counts {
+		accumulator.AddFields(logMeasurement, map[string]any{
+			"count": count,
+		}, map[string]string{
+			"level":     key.level,
+			"component": key.component,
+		})
+	}
+}
@@ -3,8 +3,12 @@ package mirakurun
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
-	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/caarlos0/env/v11"
 	"github.com/influxdata/telegraf"
@@ -16,12 +20,49 @@ import (
 //go:embed sample.conf
 var sampleConfig string
 
-const measurement = "mirakurun"
+const (
+	measurement         = "mirakurun"
+	tunersMeasurement   = "mirakurun_tuners"
+	servicesMeasurement = "mirakurun_services"
+	epgMeasurement      = "mirakurun_epg"
+	infoMeasurement     = "mirakurun_info"
+
+	tunerUsersMeasurement = "mirakurun_tuner_users"
+	endpointsMeasurement  = "mirakurun_endpoints"
+)
+
+// 到達できたかどうかの判断に使うエンドポイント
+const statusEndpoint = "status"
 
 type Plugin struct {
-	client *MirakurunClient
+	instances []*instance
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	Log       telegraf.Logger `toml:"-"`
+
+	// 複数の Mirakurun を 1 つのプロセスで監視する場合に指定する。省略時は MIRAKURUN_BASE_URL のみを監視する
+	Instances []*Instance `toml:"instances"`
 
 	MirakurunBaseURL string `toml:"-" env:"MIRAKURUN_BASE_URL" envDefault:"http://localhost:40772"`
+	// サービスごとのメトリクスはサービス数に比例して系列が増えるため、明示的に有効化した場合のみ収集する
+	GatherServices bool `toml:"-" env:"MIRAKURUN_GATHER_SERVICES" envDefault:"false"`
+	// /api/programs は番組数に比例してレスポンスが大きくなるため、明示的に有効化した場合のみ収集する
+	GatherPrograms bool `toml:"-" env:"MIRAKURUN_GATHER_PROGRAMS" envDefault:"false"`
+	// /api/config/* は Mirakurun 互換実装では提供されないことがあるため、明示的に有効化した場合のみ収集する
+	GatherConfig bool `toml:"-" env:"MIRAKURUN_GATHER_CONFIG" envDefault:"false"`
+	// ポーリング間隔の間に起きたチューナーの状態変化を捉えるため、/api/events/stream を常時購読する
+	StreamEvents bool `toml:"-" env:"MIRAKURUN_STREAM_EVENTS" envDefault:"false"`
+	// ログに出力される警告やエラーを集計するため、/api/log/stream を常時購読する
+	StreamLog bool `toml:"-" env:"MIRAKURUN_STREAM_LOG" envDefault:"false"`
+	// 空きチューナーで各チャンネルを短時間受信し、MPEG-TS を解析して受信品質を計測する
+	// 計測には時間がかかるため、バックグラウンドで計測し Gather では直近の結果を出力する
+	ProbeSignal bool `toml:"-" env:"MIRAKURUN_PROBE_SIGNAL" envDefault:"false"`
+	// 1 チャンネルあたりの受信時間
+	ProbeDuration time.Duration `toml:"-" env:"MIRAKURUN_PROBE_DURATION" envDefault:"5s"`
+	// 全チャンネルを 1 巡計測してから次の計測を始めるまでの間隔
+	ProbeInterval time.Duration `toml:"-" env:"MIRAKURUN_PROBE_INTERVAL" envDefault:"1m"`
+	// 計測するチャンネルを "GR/27,BS/BS15_0" の形式で指定する。省略時はすべてのチャンネルを計測する
+	ProbeChannels []string `toml:"-" env:"MIRAKURUN_PROBE_CHANNELS"`
 }
 
 func init() {
@@ -35,10 +76,89 @@ func (p *Plugin) Init() error {
 		return fmt.Errorf("failed to parse env: %w", err)
 	}
 
-	p.client = NewMirakurunClient(p.MirakurunBaseURL)
+	configs := p.Instances
+	if len(configs) == 0 {
+		configs = []*Instance{{Name: defaultInstanceName, BaseURL: p.MirakurunBaseURL}}
+	}
+
+	probeChannels, err := parseProbeChannels(p.ProbeChannels)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]struct{}, len(configs))
+	for _, config := range configs {
+		if config.Name == "" || config.BaseURL == "" {
+			return errors.New("name and base_url are required for each instance")
+		}
+		if _, ok := names[config.Name]; ok {
+			return fmt.Errorf("duplicate instance name: %s", config.Name)
+		}
+		names[config.Name] = struct{}{}
+
+		p.instances = append(p.instances, p.newInstance(config, probeChannels))
+	}
+
 	return nil
 }
 
+func (p *Plugin) newInstance(config *Instance, probeChannels []*MirakurunServiceChannel) *instance {
+	i := &instance{
+		name:   config.Name,
+		client: NewMirakurunClient(config.BaseURL),
+	}
+
+	i.gatherFuncs = []*gatherFunc{
+		{statusEndpoint, i.gatherStatusMetrics},
+		{"channels", i.gatherChannelsMetrics},
+		{"tuners", i.gatherTunersMetrics},
+	}
+	if p.GatherServices {
+		i.gatherFuncs = append(i.gatherFuncs, &gatherFunc{"services", i.gatherServicesMetrics})
+	}
+	if p.GatherPrograms {
+		i.gatherFuncs = append(i.gatherFuncs, &gatherFunc{"programs", i.gatherProgramsMetrics})
+	}
+	if p.GatherConfig {
+		i.gatherFuncs = append(i.gatherFuncs, &gatherFunc{"config", i.gatherConfigMetrics})
+	}
+
+	if p.StreamEvents {
+		i.listeners = append(i.listeners, newEventsListener(i.client))
+	}
+	if p.StreamLog {
+		i.listeners = append(i.listeners, newLogListener(i.client))
+	}
+	if p.ProbeSignal {
+		i.listeners = append(i.listeners, newProbeListener(i.client, p.ProbeDuration, p.ProbeInterval, probeChannels))
+	}
+
+	return i
+}
+
+func (p *Plugin) Start(accumulator telegraf.Accumulator) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	for _, i := range p.instances {
+		for _, listener := range i.listeners {
+			p.wg.Go(func() {
+				listenWithBackoff(ctx, p.Log, listener, i.accumulator(accumulator))
+			})
+		}
+	}
+
+	return nil
+}
+
+func (p *Plugin) Stop() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+
+	p.wg.Wait()
+}
+
 func (p *Plugin) SampleConfig() string {
 	return sampleConfig
 }
@@ -47,36 +167,37 @@ func (p *Plugin) Gather(accumulator telegraf.Accumulator) error {
 	var eg errgroup.Group
 	ctx := context.Background()
 
-	getherFuncs := []func(context.Context, telegraf.Accumulator) error{
-		p.gatherStatusMetrics,
-		p.gatherChannelsMetrics,
-		p.gatherTunersMetrics,
-	}
-	for _, f := range getherFuncs {
-		eg.Go(func() (err error) {
-			// 未知のレスポンス形状で panic しても execd プロセス全体を落とさない
-			defer func() {
-				if r := recover(); r != nil {
-					err = fmt.Errorf("panic while gathering metrics: %v\n%s", r, debug.Stack())
-				}
-			}()
+	for _, i := range p.instances {
+		eg.Go(func() error {
+			accumulator := i.accumulator(accumulator)
+
+			// 1 台に到達できなくても他のインスタンスの収集は続け、到達できたかどうかを up として記録する
+			// 互換実装で一部のエンドポイントが提供されない場合もあるため、/api/status を取得できたかで判断する
+			errs := i.gather(ctx, accumulator)
+			i.addEndpointMetrics(accumulator, errs)
+			accumulator.AddFields(measurement, map[string]any{
+				"up": errs[statusEndpoint] == nil,
+			}, nil)
 
-			return f(ctx, accumulator)
+			for _, listener := range i.listeners {
+				listener.gather(accumulator)
+			}
+
+			return nil
 		})
 	}
-	if err := eg.Wait(); err != nil {
-		return fmt.Errorf("failed to gather metrics: %w", err)
-	}
 
-	return nil
+	return eg.Wait()
 }
 
-func (p *Plugin) gatherStatusMetrics(ctx context.Context, accumulator telegraf.Accumulator) error {
-	status, err := p.client.GetStatus(ctx)
+func (i *instance) gatherStatusMetrics(ctx context.Context, accumulator telegraf.Accumulator) error {
+	status, err := i.client.GetStatus(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get status: %w", err)
 	}
 
+	i.addInfoMetrics(accumulator, status)
+
 	// Mahiron などの Mirakurun 互換実装は Node.js 実装固有の指標を返さない
 	// 欠けている指標を 0 として記録すると実際に 0 だった場合と区別できないため、フィールドごと出力しない
 	fields := make(map[string]any)
@@ -151,8 +272,48 @@ func (p *Plugin) gatherStatusMetrics(ctx context.Context, accumulator telegraf.A
 	return nil
 }
 
-func (p *Plugin) gatherChannelsMetrics(ctx context.Context, accumulator telegraf.Accumulator) error {
-	channels, err := p.client.GetChannels(ctx)
+// addInfoMetrics はバージョンやプラットフォームを記録し、PID の変化からプロセスの再起動を検出する
+// コンテナ内で PID 1 として動作している場合など、再起動しても PID が変わらない環境では検出できない
+func (i *instance) addInfoMetrics(accumulator telegraf.Accumulator, status *MirakurunStatus) {
+	if status.Version == "" && status.Process == nil {
+		return
+	}
+
+	tags := map[string]string{
+		"version": status.Version,
+	}
+	fields := make(map[string]any)
+
+	if status.Process != nil {
+		tags["arch"] = status.Process.Arch
+		tags["platform"] = status.Process.Platform
+
+		if pid := status.Process.PID; pid != nil {
+			i.mu.Lock()
+			if i.lastPID == nil || *i.lastPID != *pid {
+				// 初回の観測は再起動として数えない
+				if i.lastPID != nil {
+					i.processRestarts++
+				}
+				i.lastPID = pid
+				i.lastPIDSeenAt = lo.FromPtr(status.Time)
+			}
+
+			fields["pid"] = *pid
+			fields["process_restarts"] = i.processRestarts
+			// プラグインの起動前から動作していた時間は分からないため、現在の PID を初めて観測してからの経過時間を下限値として出力する
+			if status.Time != nil {
+				fields["uptime_seconds"] = (*status.Time - i.lastPIDSeenAt) / 1000
+			}
+			i.mu.Unlock()
+		}
+	}
+
+	accumulator.AddFields(infoMeasurement, fields, tags)
+}
+
+func (i *instance) gatherChannelsMetrics(ctx context.Context, accumulator telegraf.Accumulator) error {
+	channels, err := i.client.GetChannels(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get channels: %w", err)
 	}
@@ -176,23 +337,235 @@ func (p *Plugin) gatherChannelsMetrics(ctx context.Context, accumulator telegraf
 	return nil
 }
 
-func (p *Plugin) gatherTunersMetrics(ctx context.Context, accumulator telegraf.Accumulator) error {
-	tuners, err := p.client.GetTuners(ctx)
+func (i *instance) gatherTunersMetrics(ctx context.Context, accumulator telegraf.Accumulator) error {
+	tuners, err := i.client.GetTuners(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get tuners: %w", err)
 	}
 
-	accumulator.AddFields(measurement, map[string]any{
-		"tuner_available": lo.CountBy(tuners, func(t *MirakurunTuner) bool { return t.IsAvailable }),
-		"tuner_remote":    lo.CountBy(tuners, func(t *MirakurunTuner) bool { return t.IsRemote }),
-		"tuner_free":      lo.CountBy(tuners, func(t *MirakurunTuner) bool { return t.IsFree }),
-		"tuner_using":     lo.CountBy(tuners, func(t *MirakurunTuner) bool { return t.IsUsing }),
-		"tuner_fault":     lo.CountBy(tuners, func(t *MirakurunTuner) bool { return t.IsFault }),
-	}, nil)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.lastTunerUsers == nil {
+		i.lastTunerUsers = make(map[int][]*MirakurunTunerUser)
+		i.preemptions = make(map[int]int64)
+	}
+
+	// どのチューナーが異常なのかを特定できるよう、集計値ではなくチューナーごとに出力する
+	for _, tuner := range tuners {
+		if previous, ok := i.lastTunerUsers[tuner.Index]; ok {
+			i.preemptions[tuner.Index] += countPreemptions(previous, tuner.Users)
+		}
+		i.lastTunerUsers[tuner.Index] = tuner.Users
+
+		fields := tunerFields(tuner)
+		fields["preemptions"] = i.preemptions[tuner.Index]
+		accumulator.AddFields(tunersMeasurement, fields, tunerTags(tuner))
+
+		// 録画の競合を調査できるよう、どのクライアントがどのチューナーを使用しているかを出力する
+		for _, user := range tuner.Users {
+			addTunerUserMetrics(accumulator, tuner, user)
+		}
+	}
+	return nil
+}
+
+func tunerFields(tuner *MirakurunTuner) map[string]any {
+	fields := map[string]any{
+		"is_available": tuner.IsAvailable,
+		"is_free":      tuner.IsFree,
+		"is_using":     tuner.IsUsing,
+		"is_fault":     tuner.IsFault,
+		"users":        len(tuner.Users),
+	}
+	if tuner.PID != nil {
+		fields["pid"] = *tuner.PID
+	}
+
+	return fields
+}
+
+func tunerTags(tuner *MirakurunTuner) map[string]string {
+	return map[string]string{
+		"tuner_index":   strconv.Itoa(tuner.Index),
+		"tuner_name":    tuner.Name,
+		"tuner_types":   strings.Join(tuner.Types, ","),
+		"tuner_command": tuner.Command,
+		"tuner_remote":  strconv.FormatBool(tuner.IsRemote),
+	}
+}
+
+func addTunerUserMetrics(accumulator telegraf.Accumulator, tuner *MirakurunTuner, user *MirakurunTunerUser) {
+	// ユーザー ID は接続元の ip:port で接続のたびに変わり、タグにすると系列が増え続けるため、フィールドとして出力する
+	fields := map[string]any{
+		"user_id":         user.ID,
+		"disable_decoder": user.DisableDecoder,
+	}
+	if user.StreamInfo != nil {
+		var packets, drops int64
+		for _, info := range user.StreamInfo {
+			packets += info.Packet
+			drops += info.Drop
+		}
+
+		fields["packets"] = packets
+		fields["drops"] = drops
+	}
+
+	tags := map[string]string{
+		"tuner_index": strconv.Itoa(tuner.Index),
+		"tuner_name":  tuner.Name,
+		"agent":       user.Agent,
+		"priority":    strconv.Itoa(user.Priority),
+	}
+	if setting := user.StreamSetting; setting != nil {
+		if setting.Channel != nil {
+			tags["channel_type"] = setting.Channel.Type
+			tags["channel"] = setting.Channel.Channel
+		}
+		if setting.NetworkID != nil {
+			tags["network_id"] = strconv.Itoa(*setting.NetworkID)
+		}
+		if setting.ServiceID != nil {
+			tags["service_id"] = strconv.Itoa(*setting.ServiceID)
+		}
+	}
+
+	accumulator.AddFields(tunerUsersMeasurement, fields, tags)
+}
+
+// countPreemptions は前回の収集以降に、より優先度の高いユーザーにチューナーを明け渡したユーザーの数を返す
+// Mirakurun は空きチューナーがない場合に優先度の低いユーザーを切断するため、
+// 切断されたユーザーより優先度の高いユーザーが新たに使用を開始していれば横取りされたとみなす
+func countPreemptions(previous, current []*MirakurunTunerUser) int64 {
+	previousIDs := lo.SliceToMap(previous, func(u *MirakurunTunerUser) (string, struct{}) { return u.ID, struct{}{} })
+	currentIDs := lo.SliceToMap(current, func(u *MirakurunTunerUser) (string, struct{}) { return u.ID, struct{}{} })
+
+	added := lo.Filter(current, func(u *MirakurunTunerUser, _ int) bool {
+		_, ok := previousIDs[u.ID]
+		return !ok
+	})
+	if len(added) == 0 {
+		return 0
+	}
+	highest := lo.MaxBy(added, func(a, b *MirakurunTunerUser) bool { return a.Priority > b.Priority }).Priority
+
+	return int64(lo.CountBy(previous, func(u *MirakurunTunerUser) bool {
+		_, ok := currentIDs[u.ID]
+		return !ok && u.Priority < highest
+	}))
+}
+
+func (i *instance) gatherServicesMetrics(ctx context.Context, accumulator telegraf.Accumulator) error {
+	services, err := i.client.GetServices(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get services: %w", err)
+	}
+
+	for _, service := range services {
+		fields := map[string]any{
+			"has_logo":  service.HasLogoData,
+			"epg_ready": service.EPGReady,
+		}
+		if service.EPGUpdatedAt != nil {
+			fields["epg_updated_at"] = *service.EPGUpdatedAt
+		}
+
+		tags := map[string]string{
+			"network_id":   strconv.Itoa(service.NetworkID),
+			"service_id":   strconv.Itoa(service.ServiceID),
+			"service_name": service.Name,
+		}
+		if service.Channel != nil {
+			tags["channel_type"] = service.Channel.Type
+			tags["channel"] = service.Channel.Channel
+		}
+		if service.RemoteControlKeyID != nil {
+			tags["remote_control_key_id"] = strconv.Itoa(*service.RemoteControlKeyID)
+		}
+
+		accumulator.AddFields(servicesMeasurement, fields, tags)
+	}
+	return nil
+}
+
+type programsServiceKey struct {
+	networkID int
+	serviceID int
+}
+
+type programsSummary struct {
+	count int
+	// 最も遅く終了する番組の終了時刻
+	lastEndAt time.Time
+	// 現在放送中の番組があるか
+	onAir bool
+	// 現在以降に開始する番組のうち最も早い開始時刻
+	nextStartAt time.Time
+}
+
+// summarizePrograms は番組表をサービスごとに集計する
+func summarizePrograms(programs []*MirakurunProgram, now time.Time) map[programsServiceKey]*programsSummary {
+	summaries := make(map[programsServiceKey]*programsSummary)
+	for _, program := range programs {
+		key := programsServiceKey{networkID: program.NetworkID, serviceID: program.ServiceID}
+		summary, ok := summaries[key]
+		if !ok {
+			summary = new(programsSummary)
+			summaries[key] = summary
+		}
+
+		startAt := time.UnixMilli(program.StartAt)
+		endAt := startAt.Add(time.Duration(program.Duration) * time.Millisecond)
+
+		summary.count++
+		if endAt.After(summary.lastEndAt) {
+			summary.lastEndAt = endAt
+		}
+		if !startAt.After(now) && endAt.After(now) {
+			summary.onAir = true
+		}
+		if startAt.After(now) && (summary.nextStartAt.IsZero() || startAt.Before(summary.nextStartAt)) {
+			summary.nextStartAt = startAt
+		}
+	}
+
+	return summaries
+}
+
+func (i *instance) gatherProgramsMetrics(ctx context.Context, accumulator telegraf.Accumulator) error {
+	programs, err := i.client.GetPrograms(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get programs: %w", err)
+	}
+
+	now := time.Now()
+	for key, summary := range summarizePrograms(programs, now) {
+		fields := map[string]any{
+			"programs": summary.count,
+		}
+
+		// 未来の番組が 1 つもない場合、EPG の取得が止まっている可能性が高いため 0 として出力する
+		fields["horizon_seconds"] = max(int64(summary.lastEndAt.Sub(now)/time.Second), 0)
+
+		// 放送中の番組があれば空白はない。次の番組もない場合は空白の長さを定義できないため出力しない
+		switch {
+		case summary.onAir:
+			fields["next_program_gap_seconds"] = int64(0)
+		case !summary.nextStartAt.IsZero():
+			fields["next_program_gap_seconds"] = int64(summary.nextStartAt.Sub(now) / time.Second)
+		}
+
+		accumulator.AddFields(epgMeasurement, fields, map[string]string{
+			"network_id": strconv.Itoa(key.networkID),
+			"service_id": strconv.Itoa(key.serviceID),
+		})
+	}
 	return nil
 }
 
 var (
-	_ telegraf.Initializer = new(Plugin)
-	_ telegraf.Input       = new(Plugin)
+	_ telegraf.Initializer  = new(Plugin)
+	_ telegraf.Input        = new(Plugin)
+	_ telegraf.ServiceInput = new(Plugin)
 )
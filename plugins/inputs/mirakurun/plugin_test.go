@@ -1,6 +1,7 @@
 package mirakurun
 
 import (
+	"bytes"
 	"maps"
 	"net/http"
 	"net/http/httptest"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/logger"
 	"github.com/stretchr/testify/require"
 )
 
@@ -19,18 +21,41 @@ type testAccumulator struct {
 
 	mu      sync.Mutex
 	metrics []testMetric
+	errors  []error
 }
 
 type testMetric struct {
 	measurement string
 	fields      map[string]any
+	tags        map[string]string
 }
 
-func (a *testAccumulator) AddFields(measurement string, fields map[string]any, _ map[string]string, _ ...time.Time) {
+func (a *testAccumulator) AddFields(measurement string, fields map[string]any, tags map[string]string, _ ...time.Time) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	a.metrics = append(a.metrics, testMetric{measurement: measurement, fields: fields})
+	a.metrics = append(a.metrics, testMetric{measurement: measurement, fields: fields, tags: tags})
+}
+
+func (a *testAccumulator) AddError(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.errors = append(a.errors, err)
+}
+
+// metricsOf は指定した measurement のメトリクスだけを取り出す
+func (a *testAccumulator) metricsOf(measurement string) []testMetric {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var results []testMetric
+	for _, metric := range a.metrics {
+		if metric.measurement == measurement {
+			results = append(results, metric)
+		}
+	}
+	return results
 }
 
 var _ telegraf.Accumulator = new(testAccumulator)
@@ -86,10 +111,102 @@ const channelsResponse = `[
 ]`
 
 const tunersResponse = `[
-  { "isAvailable": true, "isRemote": false, "isFree": true, "isUsing": false, "isFault": false },
-  { "isAvailable": true, "isRemote": true, "isFree": false, "isUsing": true, "isFault": false }
+  {
+    "index": 0, "name": "PX-W3U4 (T)", "types": ["GR"], "command": "recpt1 --device /dev/px4video2 <channel> - -",
+    "users": [],
+    "isAvailable": true, "isRemote": false, "isFree": true, "isUsing": false, "isFault": false
+  },
+  {
+    "index": 1, "name": "remote", "types": ["BS", "CS"], "command": "",
+    "pid": 1234,
+    "users": [
+      {
+        "id": "::ffff:127.0.0.1:53152", "priority": 0, "agent": "EPGStation", "disableDecoder": false,
+        "streamSetting": { "channel": { "type": "BS", "channel": "BS15_0" }, "networkId": 4, "serviceId": 101 },
+        "streamInfo": { "0x0000": { "packet": 100, "drop": 0 }, "0x0100": { "packet": 9000, "drop": 3 } }
+      },
+      { "id": "::ffff:127.0.0.1:53154", "priority": 1, "agent": "Mirakc", "disableDecoder": true }
+    ],
+    "isAvailable": true, "isRemote": true, "isFree": false, "isUsing": true, "isFault": false
+  }
 ]`
 
+const servicesResponse = `[
+  {
+    "id": 3273601024, "serviceId": 1024, "networkId": 32736, "transportStreamId": 32736, "name": "ＮＨＫ総合１・東京", "type": 1,
+    "logoId": 0, "hasLogoData": true, "remoteControlKeyId": 1, "epgReady": true, "epgUpdatedAt": 1787131297000,
+    "channel": { "type": "GR", "channel": "27" }
+  },
+  {
+    "id": 400101, "serviceId": 101, "networkId": 4, "transportStreamId": 16625, "name": "ＮＨＫ ＢＳ", "type": 1,
+    "hasLogoData": false, "epgReady": false,
+    "channel": { "type": "BS", "channel": "BS15_0" }
+  }
+]`
+
+// 過去の番組のみを含むため、EPG の取得が止まっているサービスとして扱われる
+const programsResponse = `[
+  { "id": 327360102400001, "eventId": 1, "serviceId": 1024, "networkId": 32736, "startAt": 1700000000000, "duration": 1800000 },
+  { "id": 327360102400002, "eventId": 2, "serviceId": 1024, "networkId": 32736, "startAt": 1700001800000, "duration": 1800000 },
+  { "id": 400101000003, "eventId": 3, "serviceId": 101, "networkId": 4, "startAt": 1700000000000, "duration": 3600000 }
+]`
+
+// /api/events/stream は終わりのない JSON 配列としてイベントを送ってくる
+// 同じ状態の tuner イベントは重複して出力されない
+const eventsResponse = `[
+{"resource":"program","type":"create","data":{"id":1},"time":1787131323141}
+,
+{"resource":"tuner","type":"update","data":{"index":0,"name":"PX-W3U4 (T)","types":["GR"],"command":"","users":[],"isAvailable":true,"isRemote":false,"isFree":false,"isUsing":true,"isFault":false},"time":1787131323142}
+,
+{"resource":"tuner","type":"update","data":{"index":0,"name":"PX-W3U4 (T)","types":["GR"],"command":"","users":[],"isAvailable":true,"isRemote":false,"isFree":false,"isUsing":true,"isFault":false},"time":1787131323143}
+,
+{"resource":"tuner","type":"update","data":{"index":0,"name":"PX-W3U4 (T)","types":["GR"],"command":"","users":[],"isAvailable":true,"isRemote":false,"isFree":false,"isUsing":false,"isFault":true},"time":1787131323144}
+,
+`
+
+const logResponse = `2026-10-17T12:00:00.000+09:00 info: TunerDevice#0 spawned
+2026-10-17T12:00:01.000+09:00 warning: TunerDevice#0 process has exited with exit code=1 by signal=null (pid=1234)
+2026-10-17T12:00:02.000+09:00 error: TunerDevice#0 decoder process has died
+    at ChildProcess.exithandler (node:child_process:422:12)
+2026-10-17T12:00:03.000+09:00 info: listening on http://0.0.0.0:40772
+`
+
+// tsPacket はペイロードを持つ MPEG-TS パケットを生成する
+func tsPacket(pid uint16, cc byte, scrambled, transportError bool) []byte {
+	packet := make([]byte, tsPacketSize)
+	packet[0] = tsSyncByte
+	packet[1] = byte(pid>>8) & 0x1f
+	packet[2] = byte(pid)
+	packet[3] = 0x10 | cc&0xf
+	if transportError {
+		packet[1] |= 0x80
+	}
+	if scrambled {
+		packet[3] |= 0x80
+	}
+	return packet
+}
+
+// testTS は受信品質の計測に使う MPEG-TS
+// PID 0x100 で 2 パケットの欠落、スクランブル 1 パケット、transport_error_indicator 1 パケット、同期ずれ 1 回を含む
+var testTS = bytes.Join([][]byte{
+	tsPacket(0x100, 0, false, false),
+	tsPacket(0x100, 1, false, false),
+	// 重複パケットは不連続とみなさない
+	tsPacket(0x100, 1, false, false),
+	tsPacket(0x100, 4, true, false),
+	tsPacket(0x101, 15, false, true),
+	{0x00, 0x00, 0x00},
+	tsPacket(0x101, 0, false, false),
+	tsPacket(tsNullPID, 9, false, false),
+}, nil)
+
+const (
+	configServerResponse   = `{ "port": 40772, "logLevel": 2 }`
+	configTunersResponse   = `[{ "name": "PX-W3U4 (T)", "types": ["GR"], "isDisabled": false }, { "name": "PX-W3U4 (S)", "types": ["BS", "CS"], "isDisabled": false }, { "name": "old", "types": ["GR"], "isDisabled": true }]`
+	configChannelsResponse = `[{ "name": "NHK", "type": "GR", "channel": "27", "isDisabled": false }, { "name": "BS15", "type": "BS", "channel": "BS15_0", "isDisabled": false }, { "name": "MX", "type": "GR", "channel": "16", "isDisabled": true }]`
+)
+
 func newTestPlugin(t *testing.T, statusResponse string) *Plugin {
 	t.Helper()
 
@@ -102,6 +219,48 @@ func newTestPlugin(t *testing.T, statusResponse string) *Plugin {
 		w.Header().Set("Content-Type", "application/json")
 		_, _ = w.Write([]byte(channelsResponse))
 	})
+	mux.HandleFunc("/api/services", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(servicesResponse))
+	})
+	mux.HandleFunc("/api/programs", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(programsResponse))
+	})
+	mux.HandleFunc("/api/events/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(eventsResponse))
+		w.(http.Flusher).Flush()
+
+		// 実際の Mirakurun と同様に、クライアントが切断するまで接続を維持する
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("/api/log/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(logResponse))
+		w.(http.Flusher).Flush()
+
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("/api/channels/GR/27/stream", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Mirakurun-Priority") != "-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "video/MP2T")
+		_, _ = w.Write(testTS)
+	})
+	for name, response := range map[string]string{
+		"server":   configServerResponse,
+		"tuners":   configTunersResponse,
+		"channels": configChannelsResponse,
+	} {
+		mux.HandleFunc("/api/config/"+name, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(response))
+		})
+	}
 	mux.HandleFunc("/api/tuners", func(w http.ResponseWriter, _ *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		_, _ = w.Write([]byte(tunersResponse))
@@ -112,7 +271,9 @@ func newTestPlugin(t *testing.T, statusResponse string) *Plugin {
 
 	t.Setenv("MIRAKURUN_BASE_URL", server.URL)
 
-	plugin := new(Plugin)
+	plugin := &Plugin{
+		Log: logger.New("inputs", "mirakurun", ""),
+	}
 	require.NoError(t, plugin.Init())
 	return plugin
 }
@@ -183,16 +344,16 @@ func TestPluginGatherStatusMetrics(t *testing.T) {
 			plugin := newTestPlugin(t, test.statusResponse)
 
 			var accumulator testAccumulator
-			require.NoError(t, plugin.gatherStatusMetrics(t.Context(), &accumulator))
+			require.NoError(t, plugin.instances[0].gatherStatusMetrics(t.Context(), &accumulator))
 
+			metrics := accumulator.metricsOf(measurement)
 			if test.expected == nil {
-				require.Empty(t, accumulator.metrics)
+				require.Empty(t, metrics)
 				return
 			}
 
-			require.Len(t, accumulator.metrics, 1)
-			require.Equal(t, measurement, accumulator.metrics[0].measurement)
-			require.Equal(t, test.expected, accumulator.metrics[0].fields)
+			require.Len(t, metrics, 1)
+			require.Equal(t, test.expected, metrics[0].fields)
 		})
 	}
 }
@@ -220,12 +381,11 @@ func TestPluginGather(t *testing.T) {
 			var accumulator testAccumulator
 			require.NoError(t, plugin.Gather(&accumulator))
 
-			// status / channels / tuners の 3 つが揃って収集される
-			require.Len(t, accumulator.metrics, 3)
+			// status / info / channels / up の 4 つとチューナーごと、チューナーのユーザーごとの 2 つずつ、エンドポイントごとの 3 つが揃って収集される
+			require.Len(t, accumulator.metrics, 11)
 
 			fields := make(map[string]any)
-			for _, metric := range accumulator.metrics {
-				require.Equal(t, measurement, metric.measurement)
+			for _, metric := range accumulator.metricsOf(measurement) {
 				maps.Copy(fields, metric.fields)
 			}
 
@@ -242,13 +402,611 @@ func TestPluginGather(t *testing.T) {
 				"services_bs":  1,
 				"services_cs":  0,
 				"services_sky": 1,
-
-				"tuner_available": 2,
-				"tuner_remote":    1,
-				"tuner_free":      1,
-				"tuner_using":     1,
-				"tuner_fault":     0,
+				"up":           true,
 			})
+			require.Len(t, accumulator.metricsOf(tunersMeasurement), 2)
+
+			for _, metric := range accumulator.metrics {
+				require.Equal(t, "default", metric.tags["instance"])
+			}
+		})
+	}
+}
+
+func TestPluginGatherTunersMetrics(t *testing.T) {
+	plugin := newTestPlugin(t, mirakurunStatusResponse)
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.instances[0].gatherTunersMetrics(t.Context(), &accumulator))
+
+	tunerTags := func(index, name, types, command, remote string) map[string]string {
+		return map[string]string{
+			"tuner_index":   index,
+			"tuner_name":    name,
+			"tuner_types":   types,
+			"tuner_command": command,
+			"tuner_remote":  remote,
+		}
+	}
+
+	require.Equal(t, []testMetric{
+		{
+			// pid が返されないチューナーでは pid を出力しない
+			measurement: tunersMeasurement,
+			fields: map[string]any{
+				"is_available": true,
+				"is_free":      true,
+				"is_using":     false,
+				"is_fault":     false,
+				"users":        0,
+				"preemptions":  int64(0),
+			},
+			tags: tunerTags("0", "PX-W3U4 (T)", "GR", "recpt1 --device /dev/px4video2 <channel> - -", "false"),
+		},
+		{
+			measurement: tunersMeasurement,
+			fields: map[string]any{
+				"is_available": true,
+				"is_free":      false,
+				"is_using":     true,
+				"is_fault":     false,
+				"users":        2,
+				"pid":          1234,
+				"preemptions":  int64(0),
+			},
+			tags: tunerTags("1", "remote", "BS,CS", "", "true"),
+		},
+		{
+			measurement: tunerUsersMeasurement,
+			fields: map[string]any{
+				"user_id":         "::ffff:127.0.0.1:53152",
+				"disable_decoder": false,
+				"packets":         int64(9100),
+				"drops":           int64(3),
+			},
+			tags: map[string]string{
+				"tuner_index":  "1",
+				"tuner_name":   "remote",
+				"agent":        "EPGStation",
+				"priority":     "0",
+				"channel_type": "BS",
+				"channel":      "BS15_0",
+				"network_id":   "4",
+				"service_id":   "101",
+			},
+		},
+		{
+			// streamSetting / streamInfo が返されないユーザーではそれらを出力しない
+			measurement: tunerUsersMeasurement,
+			fields: map[string]any{
+				"user_id":         "::ffff:127.0.0.1:53154",
+				"disable_decoder": true,
+			},
+			tags: map[string]string{
+				"tuner_index": "1",
+				"tuner_name":  "remote",
+				"agent":       "Mirakc",
+				"priority":    "1",
+			},
+		},
+	}, accumulator.metrics)
+}
+
+func TestCountPreemptions(t *testing.T) {
+	user := func(id string, priority int) *MirakurunTunerUser {
+		return &MirakurunTunerUser{ID: id, Priority: priority}
+	}
+
+	tests := []struct {
+		name     string
+		previous []*MirakurunTunerUser
+		current  []*MirakurunTunerUser
+		expected int64
+	}{
+		{
+			name:     "unchanged",
+			previous: []*MirakurunTunerUser{user("a", 0)},
+			current:  []*MirakurunTunerUser{user("a", 0)},
+			expected: 0,
+		},
+		{
+			// 視聴を終了しただけのユーザーは横取りされていない
+			name:     "released",
+			previous: []*MirakurunTunerUser{user("a", 0), user("b", 1)},
+			current:  []*MirakurunTunerUser{user("b", 1)},
+			expected: 0,
+		},
+		{
+			name:     "preempted",
+			previous: []*MirakurunTunerUser{user("a", 0), user("b", 0)},
+			current:  []*MirakurunTunerUser{user("c", 2)},
+			expected: 2,
+		},
+		{
+			// 同じ優先度のユーザーへの入れ替わりは横取りではない
+			name:     "replaced with same priority",
+			previous: []*MirakurunTunerUser{user("a", 1)},
+			current:  []*MirakurunTunerUser{user("b", 1)},
+			expected: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.expected, countPreemptions(test.previous, test.current))
+		})
+	}
+}
+
+func TestPluginGatherServicesMetrics(t *testing.T) {
+	plugin := newTestPlugin(t, mirakurunStatusResponse)
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.instances[0].gatherServicesMetrics(t.Context(), &accumulator))
+
+	require.Equal(t, []testMetric{
+		{
+			measurement: servicesMeasurement,
+			fields: map[string]any{
+				"has_logo":       true,
+				"epg_ready":      true,
+				"epg_updated_at": int64(1787131297000),
+			},
+			tags: map[string]string{
+				"network_id":            "32736",
+				"service_id":            "1024",
+				"service_name":          "ＮＨＫ総合１・東京",
+				"channel_type":          "GR",
+				"channel":               "27",
+				"remote_control_key_id": "1",
+			},
+		},
+		{
+			// epgUpdatedAt / remoteControlKeyId が欠落しているサービスでは出力しない
+			measurement: servicesMeasurement,
+			fields: map[string]any{
+				"has_logo":  false,
+				"epg_ready": false,
+			},
+			tags: map[string]string{
+				"network_id":   "4",
+				"service_id":   "101",
+				"service_name": "ＮＨＫ ＢＳ",
+				"channel_type": "BS",
+				"channel":      "BS15_0",
+			},
+		},
+	}, accumulator.metrics)
+}
+
+func TestPluginGatherWithServices(t *testing.T) {
+	t.Setenv("MIRAKURUN_GATHER_SERVICES", "true")
+	plugin := newTestPlugin(t, mirakurunStatusResponse)
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.Gather(&accumulator))
+
+	require.Len(t, accumulator.metricsOf(servicesMeasurement), 2)
+}
+
+func TestSummarizePrograms(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	program := func(networkID, serviceID int, start, duration time.Duration) *MirakurunProgram {
+		return &MirakurunProgram{
+			NetworkID: networkID,
+			ServiceID: serviceID,
+			StartAt:   now.Add(start).UnixMilli(),
+			Duration:  duration.Milliseconds(),
+		}
+	}
+
+	summaries := summarizePrograms([]*MirakurunProgram{
+		// 放送中の番組と未来の番組
+		program(32736, 1024, -10*time.Minute, 30*time.Minute),
+		program(32736, 1024, 20*time.Minute, 30*time.Minute),
+		program(32736, 1024, 50*time.Minute, time.Hour),
+		// 放送中の番組がなく、30 分後に次の番組が始まる
+		program(4, 101, -time.Hour, 30*time.Minute),
+		program(4, 101, 30*time.Minute, time.Hour),
+		program(4, 101, 90*time.Minute, time.Hour),
+	}, now)
+
+	require.Equal(t, map[programsServiceKey]*programsSummary{
+		{networkID: 32736, serviceID: 1024}: {
+			count:       3,
+			lastEndAt:   now.Add(110 * time.Minute),
+			onAir:       true,
+			nextStartAt: now.Add(20 * time.Minute),
+		},
+		{networkID: 4, serviceID: 101}: {
+			count:       3,
+			lastEndAt:   now.Add(150 * time.Minute),
+			onAir:       false,
+			nextStartAt: now.Add(30 * time.Minute),
+		},
+	}, summaries)
+}
+
+func TestPluginGatherProgramsMetrics(t *testing.T) {
+	plugin := newTestPlugin(t, mirakurunStatusResponse)
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.instances[0].gatherProgramsMetrics(t.Context(), &accumulator))
+
+	metrics := accumulator.metricsOf(epgMeasurement)
+	require.Len(t, metrics, 2)
+
+	for _, metric := range metrics {
+		// 未来の番組がないため horizon は 0 になり、next_program_gap_seconds は出力されない
+		switch metric.tags["service_id"] {
+		case "1024":
+			require.Equal(t, map[string]any{"programs": 2, "horizon_seconds": int64(0)}, metric.fields)
+			require.Equal(t, map[string]string{"network_id": "32736", "service_id": "1024"}, metric.tags)
+		case "101":
+			require.Equal(t, map[string]any{"programs": 1, "horizon_seconds": int64(0)}, metric.fields)
+			require.Equal(t, map[string]string{"network_id": "4", "service_id": "101"}, metric.tags)
+		default:
+			require.Failf(t, "unexpected service", "service_id: %s", metric.tags["service_id"])
+		}
+	}
+}
+
+func TestPluginStreamEvents(t *testing.T) {
+	t.Setenv("MIRAKURUN_STREAM_EVENTS", "true")
+	plugin := newTestPlugin(t, mirakurunStatusResponse)
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.Start(&accumulator))
+	t.Cleanup(plugin.Stop)
+
+	// 状態が変化した 2 回分だけチューナーのメトリクスが即座に出力される
+	require.Eventually(t, func() bool {
+		return len(accumulator.metricsOf(tunersMeasurement)) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tuners := accumulator.metricsOf(tunersMeasurement)
+	require.Equal(t, true, tuners[0].fields["is_using"])
+	require.Equal(t, true, tuners[1].fields["is_fault"])
+
+	plugin.Stop()
+
+	var events testAccumulator
+	require.NoError(t, plugin.Gather(&events))
+
+	counts := make(map[string]any)
+	for _, metric := range events.metricsOf(eventsMeasurement) {
+		counts[metric.tags["resource"]+"_"+metric.tags["type"]] = metric.fields["count"]
+	}
+	require.Equal(t, map[string]any{
+		"program_create": int64(1),
+		"tuner_update":   int64(3),
+	}, counts)
+}
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected *logLine
+	}{
+		{
+			name: "component",
+			line: "2026-10-17T12:00:02.000+09:00 error: TunerDevice#0 decoder process has died",
+			expected: &logLine{
+				time:      time.Date(2026, 10, 17, 3, 0, 2, 0, time.UTC),
+				level:     "error",
+				component: "TunerDevice",
+				message:   "TunerDevice#0 decoder process has died",
+			},
+		},
+		{
+			name: "no component",
+			line: "2026-10-17T12:00:03.000+09:00 info: listening on http://0.0.0.0:40772",
+			expected: &logLine{
+				time:      time.Date(2026, 10, 17, 3, 0, 3, 0, time.UTC),
+				level:     "info",
+				component: "other",
+				message:   "listening on http://0.0.0.0:40772",
+			},
+		},
+		{
+			// スタックトレースの継続行は集計しない
+			name:     "continuation",
+			line:     "    at ChildProcess.exithandler (node:child_process:422:12)",
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, ok := parseLogLine(test.line)
+			if test.expected == nil {
+				require.False(t, ok)
+				return
+			}
+
+			require.True(t, ok)
+			require.True(t, test.expected.time.Equal(parsed.time))
+			parsed.time = test.expected.time
+			require.Equal(t, test.expected, parsed)
 		})
 	}
 }
+
+func TestPluginStreamLog(t *testing.T) {
+	t.Setenv("MIRAKURUN_STREAM_LOG", "true")
+	plugin := newTestPlugin(t, mirakurunStatusResponse)
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.Start(&accumulator))
+	t.Cleanup(plugin.Stop)
+
+	// 最終行まで読み終わるのを待つ
+	require.Eventually(t, func() bool {
+		var logs testAccumulator
+		plugin.instances[0].listeners[0].gather(&logs)
+		return len(logs.metricsOf(logMeasurement)) == 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	plugin.Stop()
+
+	// error 行はその場で出力される
+	errors := accumulator.metricsOf(logErrorsMeasurement)
+	require.Len(t, errors, 1)
+	require.Equal(t, map[string]any{"message": "TunerDevice#0 decoder process has died"}, errors[0].fields)
+	require.Equal(t, map[string]string{"instance": "default", "level": "error", "component": "TunerDevice"}, errors[0].tags)
+
+	var logs testAccumulator
+	require.NoError(t, plugin.Gather(&logs))
+
+	counts := make(map[string]any)
+	for _, metric := range logs.metricsOf(logMeasurement) {
+		counts[metric.tags["level"]+"_"+metric.tags["component"]] = metric.fields["count"]
+	}
+	require.Equal(t, map[string]any{
+		"info_TunerDevice":    int64(1),
+		"warning_TunerDevice": int64(1),
+		"error_TunerDevice":   int64(1),
+		"info_other":          int64(1),
+	}, counts)
+}
+
+func TestPluginGatherInstances(t *testing.T) {
+	reachable := newTestPlugin(t, mirakurunStatusResponse)
+
+	// 到達できないサーバー
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	plugin := &Plugin{
+		Log: logger.New("inputs", "mirakurun", ""),
+		Instances: []*Instance{
+			{Name: "tuner1", BaseURL: reachable.instances[0].client.baseURL},
+			{Name: "tuner2", BaseURL: unreachable.URL},
+		},
+	}
+	require.NoError(t, plugin.Init())
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.Gather(&accumulator))
+
+	// 1 台に到達できなくても Gather 全体は失敗せず、インスタンスごとに up が記録される
+	up := make(map[string]any)
+	for _, metric := range accumulator.metricsOf(measurement) {
+		if value, ok := metric.fields["up"]; ok {
+			up[metric.tags["instance"]] = value
+		}
+	}
+	require.Equal(t, map[string]any{"tuner1": true, "tuner2": false}, up)
+
+	// エンドポイントごとにエラーが記録される
+	require.Len(t, accumulator.errors, 3)
+	for _, err := range accumulator.errors {
+		require.ErrorContains(t, err, "tuner2: failed to gather")
+	}
+}
+
+func TestPluginGatherWithoutConfigEndpoint(t *testing.T) {
+	// /api/config/* を提供しない互換実装
+	mux := http.NewServeMux()
+	for path, response := range map[string]string{
+		"/api/status":   mahironStatusResponse,
+		"/api/channels": channelsResponse,
+		"/api/tuners":   tunersResponse,
+	} {
+		mux.HandleFunc(path, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(response))
+		})
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	t.Setenv("MIRAKURUN_BASE_URL", server.URL)
+	t.Setenv("MIRAKURUN_GATHER_CONFIG", "true")
+	plugin := &Plugin{
+		Log: logger.New("inputs", "mirakurun", ""),
+	}
+	require.NoError(t, plugin.Init())
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.Gather(&accumulator))
+
+	// 到達できていれば、一部のエンドポイントが失敗しても up は true になる
+	up := make([]any, 0, 1)
+	for _, metric := range accumulator.metricsOf(measurement) {
+		if value, ok := metric.fields["up"]; ok {
+			up = append(up, value)
+		}
+	}
+	require.Equal(t, []any{true}, up)
+
+	endpoints := make(map[string]any)
+	for _, metric := range accumulator.metricsOf(endpointsMeasurement) {
+		endpoints[metric.tags["endpoint"]] = metric.fields["ok"]
+		if metric.tags["endpoint"] == "config" {
+			require.Contains(t, metric.fields, "last_error")
+		}
+	}
+	require.Equal(t, map[string]any{"status": true, "channels": true, "tuners": true, "config": false}, endpoints)
+
+	require.Len(t, accumulator.errors, 1)
+	require.ErrorContains(t, accumulator.errors[0], "default: failed to gather config metrics")
+}
+
+func TestPluginInitInstances(t *testing.T) {
+	tests := []struct {
+		name      string
+		instances []*Instance
+		expected  string
+	}{
+		{
+			name:      "missing base_url",
+			instances: []*Instance{{Name: "tuner1"}},
+			expected:  "name and base_url are required for each instance",
+		},
+		{
+			name: "duplicate name",
+			instances: []*Instance{
+				{Name: "tuner1", BaseURL: "http://tuner1:40772"},
+				{Name: "tuner1", BaseURL: "http://tuner2:40772"},
+			},
+			expected: "duplicate instance name: tuner1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plugin := &Plugin{Instances: test.instances}
+			require.EqualError(t, plugin.Init(), test.expected)
+		})
+	}
+}
+
+func TestInstanceAddInfoMetrics(t *testing.T) {
+	status := func(pid int, time int64) *MirakurunStatus {
+		return &MirakurunStatus{
+			Time:    &time,
+			Version: "3.9.0-rc.4",
+			Process: &MirakurunStatusProcess{
+				Arch:     "x64",
+				Platform: "linux",
+				PID:      &pid,
+			},
+		}
+	}
+
+	var (
+		i           instance
+		accumulator testAccumulator
+	)
+	// 初回の観測、同じ PID での再観測、PID の変化 (再起動) の順に観測する
+	i.addInfoMetrics(&accumulator, status(100, 1787131323000))
+	i.addInfoMetrics(&accumulator, status(100, 1787131383000))
+	i.addInfoMetrics(&accumulator, status(200, 1787131443000))
+	i.addInfoMetrics(&accumulator, status(200, 1787131453000))
+
+	tags := map[string]string{"version": "3.9.0-rc.4", "arch": "x64", "platform": "linux"}
+	require.Equal(t, []testMetric{
+		{measurement: infoMeasurement, fields: map[string]any{"pid": 100, "process_restarts": int64(0), "uptime_seconds": int64(0)}, tags: tags},
+		{measurement: infoMeasurement, fields: map[string]any{"pid": 100, "process_restarts": int64(0), "uptime_seconds": int64(60)}, tags: tags},
+		{measurement: infoMeasurement, fields: map[string]any{"pid": 200, "process_restarts": int64(1), "uptime_seconds": int64(0)}, tags: tags},
+		{measurement: infoMeasurement, fields: map[string]any{"pid": 200, "process_restarts": int64(1), "uptime_seconds": int64(10)}, tags: tags},
+	}, accumulator.metrics)
+}
+
+func TestAnalyzeTS(t *testing.T) {
+	stats, err := analyzeTS(bytes.NewReader(testTS))
+	require.NoError(t, err)
+	require.Equal(t, &tsStats{
+		packets:          7,
+		syncErrors:       1,
+		transportErrors:  1,
+		continuityErrors: 1,
+		drops:            2,
+		scrambled:        1,
+	}, stats)
+}
+
+func TestPluginGatherSignalMetrics(t *testing.T) {
+	t.Setenv("MIRAKURUN_PROBE_SIGNAL", "true")
+	// BS には空きチューナーがないため、GR/27 のみが計測される
+	t.Setenv("MIRAKURUN_PROBE_CHANNELS", "GR/27,BS/BS15_0")
+	plugin := newTestPlugin(t, mirakurunStatusResponse)
+
+	listener := plugin.instances[0].listeners[0].(*probeListener)
+
+	var accumulator testAccumulator
+	// 計測前は何も出力しない
+	listener.gather(&accumulator)
+	require.Empty(t, accumulator.metrics)
+
+	require.NoError(t, listener.probe(t.Context(), &accumulator))
+	require.Empty(t, accumulator.errors)
+
+	// 直近の計測結果は Gather のたびに出力される
+	listener.gather(&accumulator)
+	listener.gather(&accumulator)
+	metrics := accumulator.metricsOf(signalMeasurement)
+	require.Len(t, metrics, 2)
+	require.Equal(t, map[string]string{"channel_type": "GR", "channel": "27"}, metrics[0].tags)
+	require.Greater(t, metrics[0].fields["packet_rate"], 0.0)
+
+	delete(metrics[0].fields, "packet_rate")
+	require.Equal(t, map[string]any{
+		"packets":           int64(7),
+		"sync_errors":       int64(1),
+		"transport_errors":  int64(1),
+		"continuity_errors": int64(1),
+		"drops":             int64(2),
+		"scrambled":         int64(1),
+	}, metrics[0].fields)
+}
+
+func TestParseProbeChannels(t *testing.T) {
+	channels, err := parseProbeChannels([]string{"GR/27", " BS/BS15_0"})
+	require.NoError(t, err)
+	require.Equal(t, []*MirakurunServiceChannel{
+		{Type: "GR", Channel: "27"},
+		{Type: "BS", Channel: "BS15_0"},
+	}, channels)
+
+	_, err = parseProbeChannels([]string{"GR"})
+	require.EqualError(t, err, `invalid probe channel: "GR"`)
+}
+
+func TestPluginGatherConfigMetrics(t *testing.T) {
+	plugin := newTestPlugin(t, mirakurunStatusResponse)
+	i := plugin.instances[0]
+
+	var accumulator testAccumulator
+	require.NoError(t, i.gatherConfigMetrics(t.Context(), &accumulator))
+	require.NoError(t, i.gatherConfigMetrics(t.Context(), &accumulator))
+
+	// 設定が変わっていなければ変更は出力されない
+	require.Empty(t, accumulator.metricsOf(configChangedMeasurement))
+
+	metrics := accumulator.metricsOf(configMeasurement)
+	require.Len(t, metrics, 2)
+	require.Subset(t, metrics[0].fields, map[string]any{
+		"tuners_configured": 3,
+		"tuners_disabled":   1,
+		"tuners_reported":   2,
+		"channels_enabled":  2,
+		"channels_disabled": 1,
+	})
+	require.Len(t, metrics[0].fields["channels_hash"], 64)
+	require.Equal(t, metrics[0].fields, metrics[1].fields)
+
+	// 前回の収集から channels の設定が変わった状態を再現する
+	i.lastConfigHashes["channels"] = "stale"
+	require.NoError(t, i.gatherConfigMetrics(t.Context(), &accumulator))
+
+	changes := accumulator.metricsOf(configChangedMeasurement)
+	require.Len(t, changes, 1)
+	require.Equal(t, map[string]string{"document": "channels"}, changes[0].tags)
+	require.Equal(t, map[string]any{
+		"previous_hash": "stale",
+		"hash":          metrics[0].fields["channels_hash"],
+	}, changes[0].fields)
+}
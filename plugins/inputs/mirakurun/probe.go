@@ -0,0 +1,163 @@
+package mirakurun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/samber/lo"
+)
+
+const signalMeasurement = "mirakurun_signal"
+
+// parseProbeChannels は "GR/27" の形式で指定された計測対象のチャンネルを解釈する
+func parseProbeChannels(values []string) ([]*MirakurunServiceChannel, error) {
+	channels := make([]*MirakurunServiceChannel, 0, len(values))
+	for _, value := range values {
+		channelType, channel, ok := strings.Cut(strings.TrimSpace(value), "/")
+		if !ok || channelType == "" || channel == "" {
+			return nil, fmt.Errorf("invalid probe channel: %q", value)
+		}
+
+		channels = append(channels, &MirakurunServiceChannel{Type: channelType, Channel: channel})
+	}
+
+	return channels, nil
+}
+
+type probeResult struct {
+	stats   *tsStats
+	elapsed time.Duration
+}
+
+// probeListener は Gather を待たせないよう、バックグラウンドでチャンネルを順番に短時間受信して受信品質を計測する
+type probeListener struct {
+	client   *MirakurunClient
+	duration time.Duration
+	interval time.Duration
+	channels []*MirakurunServiceChannel
+
+	mu sync.Mutex
+	// チャンネルごとの直近の計測結果
+	results map[MirakurunServiceChannel]*probeResult
+}
+
+func newProbeListener(client *MirakurunClient, duration, interval time.Duration, channels []*MirakurunServiceChannel) *probeListener {
+	return &probeListener{
+		client:   client,
+		duration: duration,
+		interval: interval,
+		channels: channels,
+		results:  make(map[MirakurunServiceChannel]*probeResult),
+	}
+}
+
+func (l *probeListener) name() string {
+	return "signal probe"
+}
+
+func (l *probeListener) listen(ctx context.Context, accumulator telegraf.Accumulator) error {
+	for {
+		if err := l.probe(ctx, accumulator); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(l.interval):
+		}
+	}
+}
+
+// probe はすべてのチャンネルを 1 巡計測し、結果を保持する
+func (l *probeListener) probe(ctx context.Context, accumulator telegraf.Accumulator) error {
+	channels := l.channels
+	if len(channels) == 0 {
+		results, err := l.client.GetChannels(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get channels: %w", err)
+		}
+
+		channels = lo.Map(results, func(c *MirakurunChannel, _ int) *MirakurunServiceChannel {
+			return &MirakurunServiceChannel{Type: c.Type, Channel: c.Channel}
+		})
+	}
+
+	for _, channel := range channels {
+		// 録画や視聴を妨げないよう、受信できる空きチューナーがある場合のみ計測する
+		tuners, err := l.client.GetTuners(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get tuners: %w", err)
+		}
+		if !lo.ContainsBy(tuners, func(t *MirakurunTuner) bool { return t.IsFree && lo.Contains(t.Types, channel.Type) }) {
+			continue
+		}
+
+		stats, elapsed, err := l.probeChannel(ctx, channel)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			accumulator.AddError(fmt.Errorf("failed to probe %s/%s: %w", channel.Type, channel.Channel, err))
+			continue
+		}
+
+		l.mu.Lock()
+		l.results[*channel] = &probeResult{stats: stats, elapsed: elapsed}
+		l.mu.Unlock()
+	}
+
+	return nil
+}
+
+// gather はチャンネルごとの直近の計測結果を出力する
+func (l *probeListener) gather(accumulator telegraf.Accumulator) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for channel, result := range l.results {
+		fields := map[string]any{
+			"packets":           result.stats.packets,
+			"sync_errors":       result.stats.syncErrors,
+			"transport_errors":  result.stats.transportErrors,
+			"continuity_errors": result.stats.continuityErrors,
+			"drops":             result.stats.drops,
+			"scrambled":         result.stats.scrambled,
+		}
+		if result.elapsed > 0 {
+			fields["packet_rate"] = float64(result.stats.packets) / result.elapsed.Seconds()
+		}
+
+		accumulator.AddFields(signalMeasurement, fields, map[string]string{
+			"channel_type": channel.Type,
+			"channel":      channel.Channel,
+		})
+	}
+}
+
+func (l *probeListener) probeChannel(ctx context.Context, channel *MirakurunServiceChannel) (*tsStats, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.duration)
+	defer cancel()
+
+	startedAt := time.Now()
+	stream, err := l.client.StreamChannel(ctx, channel.Type, channel.Channel)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer func() { _ = stream.Close() }()
+
+	stats, err := analyzeTS(stream)
+	// 計測時間の経過による打ち切りは正常な終了とみなす
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return nil, 0, err
+	}
+
+	return stats, time.Since(startedAt), nil
+}
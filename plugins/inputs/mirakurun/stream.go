@@ -0,0 +1,50 @@
+package mirakurun
+
+import (
+	"context"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+const (
+	streamMinBackoff = time.Second
+	streamMaxBackoff = time.Minute
+)
+
+// streamListener は Mirakurun のストリーミング API を常時購読し、受信した内容を集計する
+type streamListener interface {
+	// name はログに出力する購読先の名前を返す
+	name() string
+	// listen はストリームが切断されるか ctx がキャンセルされるまで購読を続ける
+	listen(ctx context.Context, accumulator telegraf.Accumulator) error
+	// gather は集計した内容を Gather のタイミングで出力する
+	gather(accumulator telegraf.Accumulator)
+}
+
+// listenWithBackoff は ctx がキャンセルされるまで、切断されるたびに指数バックオフを挟んで再接続する
+func listenWithBackoff(ctx context.Context, log telegraf.Logger, listener streamListener, accumulator telegraf.Accumulator) {
+	backoff := streamMinBackoff
+	for {
+		connectedAt := time.Now()
+		err := listener.listen(ctx, accumulator)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// 十分長く接続できていた場合は一時的な切断とみなし、バックオフを初期値に戻す
+		if time.Since(connectedAt) > streamMaxBackoff {
+			backoff = streamMinBackoff
+		}
+
+		log.Warnf("%s disconnected, reconnecting in %s: %s", listener.name(), backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, streamMaxBackoff)
+	}
+}
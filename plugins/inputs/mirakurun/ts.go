@@ -0,0 +1,118 @@
+package mirakurun
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+	tsNullPID    = 0x1fff
+)
+
+// tsStats は MPEG-TS を解析して得られる受信品質の指標
+type tsStats struct {
+	packets int64
+	// 同期バイトが見つからず読み飛ばした回数
+	syncErrors int64
+	// transport_error_indicator が立っているパケット数
+	transportErrors int64
+	// continuity_counter が不連続だった回数と、その間に欠落したと推定されるパケット数
+	continuityErrors int64
+	drops            int64
+	// transport_scrambling_control が 0 以外のパケット数
+	scrambled int64
+}
+
+// analyzeTS は r から読み取れなくなるまで MPEG-TS を解析する
+// 途中で読み取りに失敗した場合も、それまでに解析した結果を返す
+func analyzeTS(r io.Reader) (*tsStats, error) {
+	var (
+		stats  tsStats
+		lastCC = make(map[uint16]byte)
+		packet = make([]byte, tsPacketSize)
+		reader = bufio.NewReaderSize(r, tsPacketSize*1024)
+	)
+
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return &stats, ignoreEOF(err)
+		}
+		if b != tsSyncByte {
+			stats.syncErrors++
+			if err = resync(reader); err != nil {
+				return &stats, ignoreEOF(err)
+			}
+			continue
+		}
+
+		packet[0] = b
+		if _, err = io.ReadFull(reader, packet[1:]); err != nil {
+			return &stats, ignoreEOF(err)
+		}
+
+		stats.packets++
+		analyzeTSPacket(&stats, lastCC, packet)
+	}
+}
+
+func analyzeTSPacket(stats *tsStats, lastCC map[uint16]byte, packet []byte) {
+	pid := uint16(packet[1]&0x1f)<<8 | uint16(packet[2])
+	if pid == tsNullPID {
+		return
+	}
+
+	if packet[1]&0x80 != 0 {
+		stats.transportErrors++
+	}
+	if packet[3]>>6 != 0 {
+		stats.scrambled++
+	}
+
+	adaptationFieldControl := (packet[3] >> 4) & 0x3
+	hasPayload := adaptationFieldControl&0x1 != 0
+	discontinuity := adaptationFieldControl&0x2 != 0 && packet[4] > 0 && packet[5]&0x80 != 0
+	cc := packet[3] & 0xf
+
+	// continuity_counter はペイロードを持つパケットでのみ増加する
+	if !hasPayload {
+		return
+	}
+
+	if last, ok := lastCC[pid]; ok && !discontinuity {
+		expected := (last + 1) & 0xf
+		// 直前と同じ値は重複パケットとして許容されている
+		if cc != expected && cc != last {
+			stats.continuityErrors++
+			stats.drops += int64((cc - expected) & 0xf)
+		}
+	}
+	lastCC[pid] = cc
+}
+
+// resync は次の同期バイトの直前まで読み飛ばす
+func resync(reader *bufio.Reader) error {
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			return err
+		}
+		if b[0] == tsSyncByte {
+			return nil
+		}
+		if _, err = reader.Discard(1); err != nil {
+			return err
+		}
+	}
+}
+
+func ignoreEOF(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,143 @@
+package switchbot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/nasa9084/go-switchbot/v5"
+	"github.com/samber/lo"
+)
+
+type inventoryKey struct {
+	deviceType switchbot.PhysicalDeviceType
+	hub        string
+}
+
+type infraredInventoryKey struct {
+	remoteType switchbot.VirtualDeviceType
+	hub        string
+}
+
+// loadDevices は直近に取得したデバイスを返す。まだ取得できていなければその場で取得する
+func (p *Plugin) loadDevices(ctx context.Context, accumulator telegraf.Accumulator) ([]switchbot.Device, error) {
+	p.mu.Lock()
+	fetched := !p.deviceFetchedAt.IsZero()
+	devices := p.deviceList
+	p.mu.Unlock()
+
+	if fetched {
+		return devices, nil
+	}
+
+	if err := p.refreshDevices(ctx, accumulator); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.deviceList, nil
+}
+
+func (p *Plugin) refreshDevicesPeriodically(ctx context.Context, accumulator telegraf.Accumulator) {
+	ticker := time.NewTicker(p.SwitchBotDeviceListTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.refreshDevices(ctx, accumulator); err != nil {
+				accumulator.AddError(fmt.Errorf("failed to refresh devices: %w", err))
+			}
+		}
+	}
+}
+
+// refreshDevices はデバイスの一覧を取得し直し、前回から追加・削除されたデバイスを出力する
+func (p *Plugin) refreshDevices(ctx context.Context, accumulator telegraf.Accumulator) error {
+	now := time.Now()
+
+	// 1 日のリクエスト数の上限に達した場合は、直近に取得したデバイスを使い続ける
+	if !p.consumeRequest(now) {
+		return nil
+	}
+
+	devices, infraredDevices, err := p.client.Device().List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	current := make(map[string]*switchbot.Device, len(devices))
+	for _, device := range devices {
+		current[device.ID] = &device
+	}
+
+	// 初回の取得では、すべてのデバイスが追加されたとはみなさない
+	if !p.deviceFetchedAt.IsZero() {
+		for id, device := range current {
+			if _, ok := p.devices[id]; !ok {
+				addInventoryEvent(accumulator, device, "added", now)
+			}
+		}
+		for id, device := range p.devices {
+			if _, ok := current[id]; !ok {
+				addInventoryEvent(accumulator, device, "removed", now)
+			}
+		}
+	}
+
+	p.deviceList = devices
+	p.infraredDevices = infraredDevices
+	p.devices = current
+	p.deviceFetchedAt = now
+	return nil
+}
+
+func addInventoryEvent(accumulator telegraf.Accumulator, device *switchbot.Device, event string, now time.Time) {
+	tags := deviceTags(device)
+	tags["event"] = event
+
+	accumulator.AddFields("switchbot_inventory_events", map[string]any{
+		"count": 1,
+	}, tags, now)
+}
+
+func (p *Plugin) addInventoryMetrics(accumulator telegraf.Accumulator, devices []switchbot.Device) {
+	p.mu.Lock()
+	infraredDevices := p.infraredDevices
+	p.mu.Unlock()
+
+	counts := lo.CountValuesBy(devices, func(device switchbot.Device) inventoryKey {
+		return inventoryKey{deviceType: device.Type, hub: device.Hub}
+	})
+
+	for key, count := range counts {
+		accumulator.AddFields("switchbot_inventory", map[string]any{
+			"devices": count,
+		}, map[string]string{
+			"device_type": string(key.deviceType),
+			"hub_id":      key.hub,
+		})
+	}
+
+	// 赤外線リモコンはステータスを取得できず、このプラグインからコマンドも送信しないため、最後に送信されたコマンドは分からない
+	infraredCounts := lo.CountValuesBy(infraredDevices, func(device switchbot.InfraredDevice) infraredInventoryKey {
+		return infraredInventoryKey{remoteType: device.Type, hub: device.Hub}
+	})
+
+	for key, count := range infraredCounts {
+		accumulator.AddFields("switchbot_infrared_inventory", map[string]any{
+			"remotes": count,
+		}, map[string]string{
+			"remote_type": string(key.remoteType),
+			"hub_id":      key.hub,
+		})
+	}
+}
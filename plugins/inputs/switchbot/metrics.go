@@ -1,161 +1,268 @@
 package switchbot
 
-import "github.com/nasa9084/go-switchbot/v5"
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nasa9084/go-switchbot/v5"
+)
 
 type MetricSource struct {
-	Key   string
-	Value func(status *switchbot.DeviceStatus) any
+	Key string
+	// デバイスのステータスの JSON のキー。Webhook のペイロードに値が含まれるかの判定に使う
+	JSONKey string
+	Value   func(status *switchbot.DeviceStatus) any
+	// ポーリングの応答に JSONKey が含まれていない場合は出力しない
+	// ゼロ値が有効な状態を表すステータスで、値の欠損が正常な状態に見えないようにする
+	RequireKey bool
 }
 
 var (
 	AmbientBrightness = &MetricSource{
-		Key: "ambient_brightness",
+		Key:     "ambient_brightness",
+		JSONKey: "brightness",
 		Value: func(status *switchbot.DeviceStatus) any {
 			value, _ := status.Brightness.AmbientBrightness()
 			return value
 		},
 	}
 	Battery = &MetricSource{
-		Key: "battery",
+		Key:     "battery",
+		JSONKey: "battery",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.Battery
 		},
 	}
 	Brightness = &MetricSource{
-		Key: "brightness",
+		Key:     "brightness",
+		JSONKey: "brightness",
 		Value: func(status *switchbot.DeviceStatus) any {
 			value, _ := status.Brightness.Int()
 			return value
 		},
 	}
 	CO2 = &MetricSource{
-		Key: "co2",
+		Key:     "co2",
+		JSONKey: "CO2",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.CO2
 		},
 	}
 	ColorTemperature = &MetricSource{
-		Key: "color_temperature",
+		Key:     "color_temperature",
+		JSONKey: "colorTemperature",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.ColorTemperature
 		},
 	}
 	ElectricCurrent = &MetricSource{
-		Key: "electric_current",
+		Key:     "electric_current",
+		JSONKey: "electricCurrent",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.ElectricCurrent
 		},
 	}
 	ElectricityOfDay = &MetricSource{
-		Key: "electricity_of_day",
+		Key:     "electricity_of_day",
+		JSONKey: "electricityOfDay",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.ElectricityOfDay
 		},
 	}
 	FanSpeed = &MetricSource{
-		Key: "fan_speed",
+		Key:     "fan_speed",
+		JSONKey: "fanSpeed",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.FanSpeed
 		},
 	}
 	Humidity = &MetricSource{
-		Key: "humidity",
+		Key:     "humidity",
+		JSONKey: "humidity",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.Humidity
 		},
 	}
 	IsAuto = &MetricSource{
-		Key: "is_auto",
+		Key:     "is_auto",
+		JSONKey: "auto",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.IsAuto
 		},
 	}
 	IsCalibrated = &MetricSource{
-		Key: "is_calibrated",
+		Key:     "is_calibrated",
+		JSONKey: "calibrate",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.IsCalibrated
 		},
 	}
 	IsChildLock = &MetricSource{
-		Key: "is_child_lock",
+		Key:     "is_child_lock",
+		JSONKey: "childLock",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.IsChildLock
 		},
 	}
 	IsGrouped = &MetricSource{
-		Key: "is_grouped",
+		Key:     "is_grouped",
+		JSONKey: "group",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.IsGrouped
 		},
 	}
 	IsLackWater = &MetricSource{
-		Key: "is_lack_water",
+		Key:     "is_lack_water",
+		JSONKey: "lackWater",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.IsLackWater
 		},
 	}
 	IsMoveDetected = &MetricSource{
-		Key: "is_move_detected",
+		Key:     "is_move_detected",
+		JSONKey: "moveDetected",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.IsMoveDetected
 		},
 	}
 	IsMoving = &MetricSource{
-		Key: "is_moving",
+		Key:     "is_moving",
+		JSONKey: "moving",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.IsMoving
 		},
 	}
 	IsSound = &MetricSource{
-		Key: "is_sound",
+		Key:     "is_sound",
+		JSONKey: "sound",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.IsSound
 		},
 	}
 	LightLevel = &MetricSource{
-		Key: "light_level",
+		Key:     "light_level",
+		JSONKey: "lightLevel",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.LightLevel
 		},
 	}
 	NebulizationEfficiency = &MetricSource{
-		Key: "nebulization_efficiency",
+		Key:     "nebulization_efficiency",
+		JSONKey: "nebulizationEfficiency",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.NebulizationEfficiency
 		},
 	}
 	OnlineStatus = &MetricSource{
-		Key: "online_status",
+		Key:     "online_status",
+		JSONKey: "onlineStatus",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.OnlineStatus
 		},
 	}
 	SlidePosition = &MetricSource{
-		Key: "slide_position",
+		Key:     "slide_position",
+		JSONKey: "slidePosition",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.SlidePosition
 		},
 	}
 	Temperature = &MetricSource{
-		Key: "temperature",
+		Key:     "temperature",
+		JSONKey: "temperature",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.Temperature
 		},
 	}
 	Voltage = &MetricSource{
-		Key: "voltage",
+		Key:     "voltage",
+		JSONKey: "voltage",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.Voltage
 		},
 	}
 	Weight = &MetricSource{
-		Key: "weight",
+		Key:     "weight",
+		JSONKey: "weight",
 		Value: func(status *switchbot.DeviceStatus) any {
 			return status.Weight
 		},
 	}
 )
 
+// 列挙値のステータスは、文字列のフィールドに加えて数値に変換したフィールドも出力する
+// 数値は正常な状態を 0 とし、未知の値は -1 とする
+const unknownStateCode = -1
+
+// newStateSources は列挙値のステータスの文字列と数値のフィールドを作る
+func newStateSources(key, jsonKey string, value func(status *switchbot.DeviceStatus) string, codes map[string]int) (*MetricSource, *MetricSource) {
+	// Webhook では大文字で送られるなど、API によって大文字小文字が異なるため小文字に揃える
+	normalized := func(status *switchbot.DeviceStatus) string {
+		return strings.ToLower(value(status))
+	}
+
+	state := &MetricSource{
+		Key:     key,
+		JSONKey: jsonKey,
+		Value: func(status *switchbot.DeviceStatus) any {
+			return normalized(status)
+		},
+		RequireKey: true,
+	}
+	code := &MetricSource{
+		Key:     key + "_code",
+		JSONKey: jsonKey,
+		Value: func(status *switchbot.DeviceStatus) any {
+			if c, ok := codes[normalized(status)]; ok {
+				return c
+			}
+			return unknownStateCode
+		},
+		RequireKey: true,
+	}
+	return state, code
+}
+
+var (
+	LockState, LockStateCode = newStateSources("lock_state", "lockState", func(status *switchbot.DeviceStatus) string {
+		return status.LockState
+	}, map[string]int{"locked": 0, "unlocked": 1, "jammed": 2})
+	DoorState, DoorStateCode = newStateSources("door_state", "doorState", func(status *switchbot.DeviceStatus) string {
+		return status.DoorState
+	}, map[string]int{"closed": 0, "opened": 1})
+	OpenState, OpenStateCode = newStateSources("open_state", "openState", func(status *switchbot.DeviceStatus) string {
+		return string(status.OpenState)
+	}, map[string]int{"close": 0, "open": 1, "timeoutnotclose": 2})
+	WorkingStatus, WorkingStatusCode = newStateSources("working_status", "workingStatus", func(status *switchbot.DeviceStatus) string {
+		return string(status.WorkingStatus)
+	}, map[string]int{
+		"standby":          0,
+		"clearing":         1,
+		"gotochargebase":   2,
+		"charging":         3,
+		"chargedone":       4,
+		"dormant":          5,
+		"inremotecontrol":  6,
+		"industcollecting": 7,
+		"introuble":        8,
+		"paused":           9,
+	})
+	NightStatus, NightStatusCode = newStateSources("night_status", "nightStatus", func(status *switchbot.DeviceStatus) string {
+		return string(status.NightStatus)
+	}, map[string]int{"off": 0, "1": 1, "2": 2})
+	// Webhook の detectionState は、ステータスの status に変換してから扱う
+	LeakStatus, LeakStatusCode = newStateSources("leak_status", "status", func(status *switchbot.DeviceStatus) string {
+		switch status.LeakStatus {
+		case switchbot.WaterLeakStatusDry:
+			return "dry"
+		case switchbot.WaterLeakStatusLeakDetected:
+			return "leak_detected"
+		default:
+			return strconv.Itoa(int(status.LeakStatus))
+		}
+	}, map[string]int{"dry": 0, "leak_detected": 1})
+)
+
 var SupportedMetrics = map[switchbot.PhysicalDeviceType][]*MetricSource{
 	// https://github.com/OpenWonderLabs/SwitchBotAPI/blob/main/README.md#responses-1
 	switchbot.Bot:                      {Battery},
@@ -166,13 +273,13 @@ var SupportedMetrics = map[switchbot.PhysicalDeviceType][]*MetricSource{
 	switchbot.MeterPro:                 {Battery, Temperature, Humidity},
 	switchbot.MeterProCO2:              {Battery, Temperature, Humidity, CO2},
 	switchbot.WoIOSensor:               {Battery, Temperature, Humidity},
-	switchbot.Lock:                     {Battery /* lockState, doorState */, IsCalibrated},
-	"Smart Lock Pro":                   {Battery /* lockState, doorState */, IsCalibrated},
+	switchbot.Lock:                     {Battery, LockState, LockStateCode, DoorState, DoorStateCode, IsCalibrated},
+	"Smart Lock Pro":                   {Battery, LockState, LockStateCode, DoorState, DoorStateCode, IsCalibrated},
 	switchbot.KeyPad:                   {},
 	switchbot.KeyPadTouch:              {},
 	switchbot.MotionSensor:             {Battery, IsMoveDetected, AmbientBrightness},
-	switchbot.ContactSensor:            {Battery, IsMoveDetected /* openState */, AmbientBrightness},
-	"Water Detector":                   {Battery /* status */},
+	switchbot.ContactSensor:            {Battery, IsMoveDetected, OpenState, OpenStateCode, AmbientBrightness},
+	"Water Detector":                   {Battery, LeakStatus, LeakStatusCode},
 	switchbot.CeilingLight:             {Brightness, ColorTemperature},
 	switchbot.CeilingLightPro:          {Brightness, ColorTemperature},
 	switchbot.PlugMiniUS:               {Voltage, Weight, ElectricityOfDay, ElectricCurrent},
@@ -180,13 +287,13 @@ var SupportedMetrics = map[switchbot.PhysicalDeviceType][]*MetricSource{
 	switchbot.Plug:                     {},
 	switchbot.StripLight:               {Brightness},
 	switchbot.ColorBulb:                {Brightness, ColorTemperature},
-	switchbot.RobotVacuumCleanerS1:     { /* workingStatus */ OnlineStatus, Battery},
-	switchbot.RobotVacuumCleanerS1Plus: { /* workingStatus */ OnlineStatus, Battery},
-	"K10+":                             { /* workingStatus */ OnlineStatus, Battery},
-	"K10+ Pro":                         { /* workingStatus */ OnlineStatus, Battery},
-	"Robot Vacuum Cleaner S10":         { /* workingStatus */ OnlineStatus, Battery /* waterBaseBatterym, taskType */},
+	switchbot.RobotVacuumCleanerS1:     {WorkingStatus, WorkingStatusCode, OnlineStatus, Battery},
+	switchbot.RobotVacuumCleanerS1Plus: {WorkingStatus, WorkingStatusCode, OnlineStatus, Battery},
+	"K10+":                             {WorkingStatus, WorkingStatusCode, OnlineStatus, Battery},
+	"K10+ Pro":                         {WorkingStatus, WorkingStatusCode, OnlineStatus, Battery},
+	"Robot Vacuum Cleaner S10":         {WorkingStatus, WorkingStatusCode, OnlineStatus, Battery /* waterBaseBatterym, taskType */},
 	switchbot.Humidifier:               {Humidity, Temperature, NebulizationEfficiency, IsAuto, IsChildLock, IsSound, IsLackWater},
 	switchbot.BlindTilt:                {IsCalibrated, IsGrouped, IsMoving, SlidePosition},
 	switchbot.Hub2:                     {Temperature, LightLevel, Humidity},
-	"Battery Circulator Fan":           {Battery /* nightStatus */, FanSpeed},
+	"Battery Circulator Fan":           {Battery, NightStatus, NightStatusCode, FanSpeed},
 }
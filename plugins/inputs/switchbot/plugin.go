@@ -5,11 +5,16 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/caarlos0/env/v11"
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/nasa9084/go-switchbot/v5"
+	"github.com/samber/lo"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -18,10 +23,46 @@ var sampleConfig string
 
 type Plugin struct {
 	client *switchbot.Client
+	server *http.Server
+	wg     sync.WaitGroup
 	Log    telegraf.Logger `toml:"-"`
 
+	// ステータスの取得 API の応答に含まれていたキー
+	statuses *statusKeysRecorder
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+
+	// 直近に取得したデバイス。Webhook の送信元の特定にも使う
+	deviceList      []switchbot.Device
+	devices         map[string]*switchbot.Device
+	infraredDevices []switchbot.InfraredDevice
+	deviceFetchedAt time.Time
+	// Webhook でステータスが届いた時刻
+	lastPushedAt map[string]time.Time
+	// デバイスごとに最後にポーリングした時刻
+	lastPolledAt map[string]time.Time
+	// 当日の API のリクエスト数。メモリ上でのみ数えるため、プロセスを再起動すると 0 から数え直す
+	quotaDay      time.Time
+	requestsToday int
+
 	SwitchBotOpenToken string `toml:"-" env:"SWITCHBOT_OPEN_TOKEN"`
 	SwitchBotSecretKey string `toml:"-" env:"SWITCHBOT_SECRET_KEY"`
+	// API の 1 日あたりのリクエスト数の上限。SwitchBot の上限より少し少なくしておく
+	// 再起動前のリクエスト数は引き継がないため、同じ日に再起動すると上限を超えることがある
+	SwitchBotDailyBudget int `toml:"-" env:"SWITCHBOT_DAILY_BUDGET" envDefault:"9500"`
+	// デバイスの一覧を取得し直す間隔
+	SwitchBotDeviceListTTL time.Duration `toml:"-" env:"SWITCHBOT_DEVICE_LIST_TTL" envDefault:"1h"`
+
+	// Webhook を受け付けるアドレス。空の場合は Webhook を受け付けない
+	SwitchBotWebhookListen string `toml:"-" env:"SWITCHBOT_WEBHOOK_LISTEN"`
+	SwitchBotWebhookPath   string `toml:"-" env:"SWITCHBOT_WEBHOOK_PATH" envDefault:"/webhook"`
+	// SwitchBot に登録する Webhook の URL。空の場合は登録しない
+	SwitchBotWebhookURL string `toml:"-" env:"SWITCHBOT_WEBHOOK_URL"`
+	// Webhook の URL のクエリパラメータ token に含める値。Webhook を受け付ける場合は必須
+	SwitchBotWebhookToken string `toml:"-" env:"SWITCHBOT_WEBHOOK_TOKEN"`
+	// この期間内に Webhook でステータスが届いたデバイスはポーリングしない
+	SwitchBotWebhookStaleAfter time.Duration `toml:"-" env:"SWITCHBOT_WEBHOOK_STALE_AFTER" envDefault:"1h"`
 }
 
 func init() {
@@ -38,11 +79,55 @@ func (p *Plugin) Init() error {
 	if p.SwitchBotOpenToken == "" || p.SwitchBotSecretKey == "" {
 		return errors.New("open token and secret key are required")
 	}
+	if p.SwitchBotDailyBudget <= 0 {
+		return errors.New("daily budget must be positive")
+	}
+	if p.SwitchBotWebhookListen != "" && p.SwitchBotWebhookToken == "" {
+		return errors.New("webhook token is required to receive webhooks")
+	}
+	if p.SwitchBotDeviceListTTL <= 0 {
+		return errors.New("device list ttl must be positive")
+	}
 
-	p.client = switchbot.New(p.SwitchBotOpenToken, p.SwitchBotSecretKey)
+	p.statuses = newStatusKeysRecorder(http.DefaultTransport)
+	p.client = switchbot.New(p.SwitchBotOpenToken, p.SwitchBotSecretKey, switchbot.WithHTTPClient(&http.Client{
+		Transport: p.statuses,
+	}))
+	p.devices = make(map[string]*switchbot.Device)
+	p.lastPushedAt = make(map[string]time.Time)
+	p.lastPolledAt = make(map[string]time.Time)
 	return nil
 }
 
+func (p *Plugin) Start(accumulator telegraf.Accumulator) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	// 最初の Gather より前に届いた Webhook の送信元を特定できるよう、先にデバイスを取得しておく
+	if err := p.refreshDevices(ctx, accumulator); err != nil {
+		p.Log.Warnf("failed to refresh devices: %v", err)
+	}
+
+	p.wg.Go(func() {
+		p.refreshDevicesPeriodically(ctx, accumulator)
+	})
+
+	if p.SwitchBotWebhookListen == "" {
+		return nil
+	}
+
+	return p.startWebhook(accumulator)
+}
+
+func (p *Plugin) Stop() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+
+	p.stopWebhook()
+	p.wg.Wait()
+}
+
 func (p *Plugin) SampleConfig() string {
 	return sampleConfig
 }
@@ -50,57 +135,72 @@ func (p *Plugin) SampleConfig() string {
 func (p *Plugin) Gather(accumulator telegraf.Accumulator) error {
 	ctx := context.Background()
 
-	devices, err := p.queryDevices(ctx)
+	devices, err := p.loadDevices(ctx, accumulator)
 	if err != nil {
-		return fmt.Errorf("failed to query devices: %w", err)
+		return fmt.Errorf("failed to load devices: %w", err)
 	}
+	p.addInventoryMetrics(accumulator, devices)
+
+	now := time.Now()
 
+	// Webhook でステータスが届いているデバイスはポーリングしない
+	targets := lo.Filter(devices, func(device switchbot.Device, _ int) bool {
+		return len(SupportedMetrics[device.Type]) > 0 && !p.isPushed(device.ID, now)
+	})
+	intervals := p.pollIntervals(targets, now)
+
+	var polled, skipped atomic.Int64
 	eg, egctx := errgroup.WithContext(ctx)
-	for _, device := range devices {
+	for _, device := range targets {
 		eg.Go(func() error {
-			metrics := SupportedMetrics[device.Type]
-			if len(metrics) == 0 {
+			// 1 日のリクエスト数の上限を超えないよう、間隔が空いていないデバイスはポーリングしない
+			if !p.reservePoll(device.ID, intervals[device.ID], now) {
+				skipped.Add(1)
 				return nil
 			}
+			polled.Add(1)
 
 			status, err := p.client.Device().Status(egctx, device.ID)
 			if err != nil {
 				return fmt.Errorf("failed to get status for %s: %w", device.ID, err)
 			}
 
+			keys := p.statuses.statusKeys(device.ID)
 			fields := map[string]any{}
-			for _, m := range metrics {
+			for _, m := range SupportedMetrics[device.Type] {
+				// ゼロ値が有効な状態を表すステータスは、値が含まれていない場合に出力しない
+				if _, ok := keys[m.JSONKey]; m.RequireKey && !ok {
+					continue
+				}
+
 				fields[m.Key] = m.Value(&status)
 			}
 
-			accumulator.AddFields("switchbot", fields, map[string]string{
-				"device_id":   device.ID,
-				"device_name": device.Name,
-				"device_type": string(device.Type),
-				"hub_id":      device.Hub,
-			})
+			accumulator.AddFields("switchbot", fields, deviceTags(&device))
 			return nil
 		})
 	}
 
-	if err = eg.Wait(); err != nil {
+	err = eg.Wait()
+	p.addQuotaMetrics(accumulator, int(polled.Load()), int(skipped.Load()))
+	if err != nil {
 		return fmt.Errorf("failed to gather metrics: %w", err)
 	}
 
 	return nil
 }
 
-func (p *Plugin) queryDevices(ctx context.Context) ([]switchbot.Device, error) {
-	// NOTE: InfraredDevice not supported
-	devices, _, err := p.client.Device().List(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list devices: %w", err)
+func deviceTags(device *switchbot.Device) map[string]string {
+	return map[string]string{
+		"device_id":   device.ID,
+		"device_name": device.Name,
+		"device_type": string(device.Type),
+		"hub_id":      device.Hub,
 	}
-
-	return devices, nil
 }
 
 var (
-	_ telegraf.Initializer = new(Plugin)
-	_ telegraf.Input       = new(Plugin)
+	_ telegraf.Initializer  = new(Plugin)
+	_ telegraf.Input        = new(Plugin)
+	_ telegraf.ServiceInput = new(Plugin)
 )
@@ -0,0 +1,542 @@
+package switchbot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/logger"
+	"github.com/nasa9084/go-switchbot/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// testAccumulator は AddFields で記録されたメトリクスを検証するための最小実装
+// telegraf/testutil は testcontainers に依存し依存関係が重いため、ここでは埋め込みで interface を満たす
+type testAccumulator struct {
+	telegraf.Accumulator
+
+	mu      sync.Mutex
+	metrics []testMetric
+	errors  []error
+}
+
+type testMetric struct {
+	measurement string
+	fields      map[string]any
+	tags        map[string]string
+	timestamp   time.Time
+}
+
+func (a *testAccumulator) AddFields(measurement string, fields map[string]any, tags map[string]string, timestamp ...time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	metric := testMetric{measurement: measurement, fields: fields, tags: tags}
+	if len(timestamp) > 0 {
+		metric.timestamp = timestamp[0]
+	}
+	a.metrics = append(a.metrics, metric)
+}
+
+func (a *testAccumulator) AddError(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.errors = append(a.errors, err)
+}
+
+// metricsOf は指定した measurement のメトリクスだけを取り出す
+func (a *testAccumulator) metricsOf(measurement string) []testMetric {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var results []testMetric
+	for _, metric := range a.metrics {
+		if metric.measurement == measurement {
+			results = append(results, metric)
+		}
+	}
+	return results
+}
+
+var _ telegraf.Accumulator = new(testAccumulator)
+
+const testWebhookToken = "secret"
+
+// SwitchBot が送信する Webhook のペイロード
+const meterWebhookPayload = `{
+  "eventType": "changeReport",
+  "eventVersion": "1",
+  "context": {
+    "deviceType": "WoMeterPlus",
+    "deviceMac": "c2:71:11:1e:c0:ab",
+    "temperature": 22.5,
+    "scale": "CELSIUS",
+    "humidity": 31,
+    "battery": 100,
+    "timeOfSample": 1787131323141
+  }
+}`
+
+const contactSensorWebhookPayload = `{
+  "eventType": "changeReport",
+  "eventVersion": "1",
+  "context": {
+    "deviceType": "WoContact",
+    "deviceMac": "D0:C8:41:1E:C0:AB",
+    "detectionState": "NOT_DETECTED",
+    "doorMode": "OUT_DOOR",
+    "brightness": "dim",
+    "openState": "open",
+    "battery": 95,
+    "timeOfSample": 1787131323141
+  }
+}`
+
+const motionSensorWebhookPayload = `{
+  "eventType": "changeReport",
+  "eventVersion": "1",
+  "context": {
+    "deviceType": "WoPresence",
+    "deviceMac": "E0:C8:41:1E:C0:AB",
+    "detectionState": "DETECTED",
+    "battery": 80,
+    "timeOfSample": 1787131323141
+  }
+}`
+
+const waterDetectorWebhookPayload = `{
+  "eventType": "changeReport",
+  "eventVersion": "1",
+  "context": {
+    "deviceType": "Water Detector",
+    "deviceMac": "F0:C8:41:1E:C0:AB",
+    "detectionState": 1,
+    "battery": 90,
+    "timeOfSample": 1787131323141
+  }
+}`
+
+func newTestWebhookPlugin(t *testing.T) (*Plugin, *testAccumulator, *httptest.Server) {
+	t.Helper()
+
+	plugin := &Plugin{
+		Log:                        logger.New("inputs", "switchbot", ""),
+		SwitchBotDailyBudget:       9500,
+		SwitchBotDeviceListTTL:     time.Hour,
+		SwitchBotWebhookPath:       "/webhook",
+		SwitchBotWebhookToken:      testWebhookToken,
+		SwitchBotWebhookStaleAfter: time.Hour,
+		devices: map[string]*switchbot.Device{
+			"C271111EC0AB": {ID: "C271111EC0AB", Name: "Meter", Type: switchbot.MeterPlus, Hub: "HUB"},
+			"D0C8411EC0AB": {ID: "D0C8411EC0AB", Name: "Door", Type: switchbot.ContactSensor, Hub: "HUB"},
+			"E0C8411EC0AB": {ID: "E0C8411EC0AB", Name: "Motion", Type: switchbot.MotionSensor, Hub: "HUB"},
+			"F0C8411EC0AB": {ID: "F0C8411EC0AB", Name: "Leak", Type: "Water Detector", Hub: "HUB"},
+		},
+		lastPushedAt: make(map[string]time.Time),
+		lastPolledAt: make(map[string]time.Time),
+	}
+
+	accumulator := &testAccumulator{}
+	server := httptest.NewServer(plugin.webhookHandler(accumulator))
+	t.Cleanup(server.Close)
+
+	return plugin, accumulator, server
+}
+
+// testAPI はパスごとのレスポンスを差し替えられる SwitchBot API のモック
+type testAPI struct {
+	mu        sync.Mutex
+	responses map[string]string
+}
+
+func (a *testAPI) setResponse(path, response string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.responses[path] = response
+}
+
+func newTestAPIPlugin(t *testing.T, responses map[string]string) (*Plugin, *testAPI) {
+	t.Helper()
+
+	api := &testAPI{responses: responses}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		response, ok := api.responses[r.URL.Path]
+		api.mu.Unlock()
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+
+	plugin := &Plugin{
+		Log:                        logger.New("inputs", "switchbot", ""),
+		SwitchBotDailyBudget:       9500,
+		SwitchBotDeviceListTTL:     time.Hour,
+		SwitchBotWebhookStaleAfter: time.Hour,
+		statuses:                   newStatusKeysRecorder(http.DefaultTransport),
+		devices:                    make(map[string]*switchbot.Device),
+		lastPushedAt:               make(map[string]time.Time),
+		lastPolledAt:               make(map[string]time.Time),
+	}
+	plugin.client = switchbot.New("token", "secret", switchbot.WithEndpoint(server.URL), switchbot.WithHTTPClient(&http.Client{
+		Transport: plugin.statuses,
+	}))
+
+	return plugin, api
+}
+
+func postWebhook(t *testing.T, server *httptest.Server, token, payload string) int {
+	t.Helper()
+
+	response, err := http.Post(server.URL+"/webhook?token="+token, "application/json", strings.NewReader(payload))
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	return response.StatusCode
+}
+
+func TestWebhookRejectsInvalidToken(t *testing.T) {
+	plugin, accumulator, server := newTestWebhookPlugin(t)
+
+	require.Equal(t, http.StatusBadRequest, postWebhook(t, server, "", meterWebhookPayload))
+	require.Equal(t, http.StatusBadRequest, postWebhook(t, server, "wrong", meterWebhookPayload))
+
+	require.Empty(t, accumulator.metricsOf("switchbot"))
+	require.False(t, plugin.isPushed("C271111EC0AB", time.Now()))
+}
+
+func TestWebhookRejectsUnknownDevice(t *testing.T) {
+	_, accumulator, server := newTestWebhookPlugin(t)
+
+	payload := strings.ReplaceAll(meterWebhookPayload, "c2:71:11:1e:c0:ab", "00:00:00:00:00:00")
+	require.Equal(t, http.StatusBadRequest, postWebhook(t, server, testWebhookToken, payload))
+	require.Empty(t, accumulator.metricsOf("switchbot"))
+}
+
+func TestWebhook(t *testing.T) {
+	plugin, accumulator, server := newTestWebhookPlugin(t)
+
+	require.Equal(t, http.StatusNoContent, postWebhook(t, server, testWebhookToken, meterWebhookPayload))
+
+	metrics := accumulator.metricsOf("switchbot")
+	require.Len(t, metrics, 1)
+
+	// MAC アドレスは区切り文字を除いた大文字のデバイス ID として扱う
+	require.Equal(t, map[string]string{
+		"device_id":   "C271111EC0AB",
+		"device_name": "Meter",
+		"device_type": "MeterPlus",
+		"hub_id":      "HUB",
+	}, metrics[0].tags)
+	require.Equal(t, map[string]any{
+		"battery":     100,
+		"temperature": 22.5,
+		"humidity":    31,
+	}, metrics[0].fields)
+	require.Equal(t, time.UnixMilli(1787131323141), metrics[0].timestamp)
+
+	// すべてのキーが含まれるため、ポーリングの対象から外れる
+	require.True(t, plugin.isPushed("C271111EC0AB", time.Now()))
+}
+
+func TestWebhookContactSensor(t *testing.T) {
+	plugin, accumulator, server := newTestWebhookPlugin(t)
+
+	require.Equal(t, http.StatusNoContent, postWebhook(t, server, testWebhookToken, contactSensorWebhookPayload))
+
+	metrics := accumulator.metricsOf("switchbot")
+	require.Len(t, metrics, 1)
+
+	// detectionState はステータスの moveDetected として扱う
+	require.Equal(t, false, metrics[0].fields["is_move_detected"])
+	require.Equal(t, 95, metrics[0].fields["battery"])
+	require.Equal(t, "open", metrics[0].fields["open_state"])
+	require.Equal(t, switchbot.AmbientBrightness("dim"), metrics[0].fields["ambient_brightness"])
+
+	require.True(t, plugin.isPushed("D0C8411EC0AB", time.Now()))
+}
+
+func TestWebhookPartialKeys(t *testing.T) {
+	plugin, accumulator, server := newTestWebhookPlugin(t)
+
+	require.Equal(t, http.StatusNoContent, postWebhook(t, server, testWebhookToken, motionSensorWebhookPayload))
+
+	metrics := accumulator.metricsOf("switchbot")
+	require.Len(t, metrics, 1)
+	require.Equal(t, map[string]any{
+		"battery":          80,
+		"is_move_detected": true,
+	}, metrics[0].fields)
+
+	// 人感センサーの Webhook には brightness が含まれないため、ポーリングを続ける
+	require.False(t, plugin.isPushed("E0C8411EC0AB", time.Now()))
+}
+
+func TestWebhookWaterDetector(t *testing.T) {
+	plugin, accumulator, server := newTestWebhookPlugin(t)
+
+	require.Equal(t, http.StatusNoContent, postWebhook(t, server, testWebhookToken, waterDetectorWebhookPayload))
+
+	metrics := accumulator.metricsOf("switchbot")
+	require.Len(t, metrics, 1)
+
+	// detectionState はステータスの status として扱う
+	require.Equal(t, map[string]any{
+		"battery":          90,
+		"leak_status":      "leak_detected",
+		"leak_status_code": 1,
+	}, metrics[0].fields)
+	require.True(t, plugin.isPushed("F0C8411EC0AB", time.Now()))
+}
+
+func TestRegisterWebhookRespectsBudget(t *testing.T) {
+	plugin := &Plugin{
+		SwitchBotDailyBudget: 1,
+		SwitchBotWebhookURL:  "https://example.com/webhook",
+	}
+	plugin.consumeRequest(time.Now())
+
+	// 上限に達している場合は API を呼び出さない
+	require.ErrorIs(t, plugin.registerWebhook(t.Context()), errDailyBudgetExhausted)
+	require.Equal(t, 1, plugin.requestsToday)
+}
+
+func TestInitRequiresWebhookToken(t *testing.T) {
+	t.Setenv("SWITCHBOT_OPEN_TOKEN", "token")
+	t.Setenv("SWITCHBOT_SECRET_KEY", "secret")
+	t.Setenv("SWITCHBOT_WEBHOOK_LISTEN", ":8080")
+
+	require.Error(t, (&Plugin{}).Init())
+
+	t.Setenv("SWITCHBOT_WEBHOOK_TOKEN", testWebhookToken)
+	require.NoError(t, (&Plugin{}).Init())
+}
+
+func TestConsumeRequest(t *testing.T) {
+	plugin := &Plugin{SwitchBotDailyBudget: 2}
+	now := time.Date(2026, 10, 17, 23, 0, 0, 0, time.Local)
+
+	require.True(t, plugin.consumeRequest(now))
+	require.True(t, plugin.consumeRequest(now))
+	require.False(t, plugin.consumeRequest(now))
+
+	// 日付が変わると数え直す
+	require.True(t, plugin.consumeRequest(now.Add(2*time.Hour)))
+	require.Equal(t, 1, plugin.requestsToday)
+}
+
+func TestPollIntervals(t *testing.T) {
+	plugin := &Plugin{
+		SwitchBotDailyBudget:   9500,
+		SwitchBotDeviceListTTL: time.Hour,
+	}
+	now := time.Date(2026, 10, 17, 0, 0, 0, 0, time.Local)
+	devices := []switchbot.Device{
+		{ID: "meter", Type: switchbot.Meter},
+		{ID: "plug", Type: switchbot.PlugMiniJP},
+		{ID: "bot", Type: switchbot.Bot},
+	}
+
+	intervals := plugin.pollIntervals(devices, now)
+
+	// 変化の遅いデバイスは他のデバイスの slowPollFactor 倍の間隔になる
+	require.Equal(t, intervals["plug"], intervals["bot"])
+	require.InDelta(t, float64(intervals["plug"]*slowPollFactor), float64(intervals["meter"]), float64(time.Millisecond))
+
+	// 一覧の取得分を除いたリクエスト数で 1 日をまかなえる
+	var requests float64
+	for _, interval := range intervals {
+		requests += float64(24*time.Hour) / float64(interval)
+	}
+	require.InDelta(t, 9500-24, requests, 1)
+
+	// 上限に達した後は当日中にポーリングしない
+	plugin.requestsToday = 9500
+	for _, interval := range plugin.pollIntervals(devices, now.Add(time.Hour)) {
+		require.Equal(t, 23*time.Hour, interval)
+	}
+}
+
+func TestReservePoll(t *testing.T) {
+	plugin := &Plugin{
+		SwitchBotDailyBudget: 1,
+		lastPolledAt:         make(map[string]time.Time),
+	}
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.Local)
+
+	require.True(t, plugin.reservePoll("a", time.Minute, now))
+	require.False(t, plugin.reservePoll("a", time.Minute, now.Add(time.Second)))
+
+	// 上限に達して拒否されたデバイスは、ポーリングしたとはみなさない
+	require.False(t, plugin.reservePoll("b", time.Minute, now))
+	require.NotContains(t, plugin.lastPolledAt, "b")
+}
+
+func TestInitValidatesDailyBudget(t *testing.T) {
+	t.Setenv("SWITCHBOT_OPEN_TOKEN", "token")
+	t.Setenv("SWITCHBOT_SECRET_KEY", "secret")
+	t.Setenv("SWITCHBOT_DAILY_BUDGET", "0")
+
+	require.Error(t, (&Plugin{}).Init())
+}
+
+func TestStateSources(t *testing.T) {
+	// Webhook とポーリングで大文字小文字が異なっても同じ値になる
+	for _, lockState := range []string{"LOCKED", "locked"} {
+		status := &switchbot.DeviceStatus{LockState: lockState}
+		require.Equal(t, "locked", LockState.Value(status))
+		require.Equal(t, 0, LockStateCode.Value(status))
+	}
+
+	status := &switchbot.DeviceStatus{
+		OpenState:     switchbot.ContactTimeoutNotClose,
+		WorkingStatus: switchbot.CleanerInTrouble,
+		LeakStatus:    switchbot.WaterLeakStatusLeakDetected,
+	}
+	require.Equal(t, "timeoutnotclose", OpenState.Value(status))
+	require.Equal(t, 2, OpenStateCode.Value(status))
+	require.Equal(t, 8, WorkingStatusCode.Value(status))
+	require.Equal(t, 9, WorkingStatusCode.Value(&switchbot.DeviceStatus{WorkingStatus: "Paused"}))
+	require.Equal(t, "leak_detected", LeakStatus.Value(status))
+	require.Equal(t, 1, LeakStatusCode.Value(status))
+
+	// 未知の値は -1 とする
+	require.Equal(t, unknownStateCode, DoorStateCode.Value(&switchbot.DeviceStatus{DoorState: "unknown"}))
+	require.Equal(t, unknownStateCode, LeakStatusCode.Value(&switchbot.DeviceStatus{LeakStatus: 2}))
+}
+
+func TestGatherOmitsMissingStates(t *testing.T) {
+	plugin, _ := newTestAPIPlugin(t, map[string]string{
+		"/v1.1/devices": `{ "statusCode": 100, "body": { "deviceList": [
+  { "deviceId": "LEAK1", "deviceName": "Kitchen", "deviceType": "Water Detector", "hubDeviceId": "HUB" },
+  { "deviceId": "LEAK2", "deviceName": "Bath", "deviceType": "Water Detector", "hubDeviceId": "HUB" }
+], "infraredRemoteList": [] } }`,
+		"/v1.1/devices/LEAK1/status": `{ "statusCode": 100, "body": { "deviceId": "LEAK1", "deviceType": "Water Detector", "battery": 100 } }`,
+		"/v1.1/devices/LEAK2/status": `{ "statusCode": 100, "body": { "deviceId": "LEAK2", "deviceType": "Water Detector", "battery": 90, "status": 1 } }`,
+	})
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.Gather(&accumulator))
+
+	fields := make(map[string]map[string]any)
+	for _, metric := range accumulator.metricsOf("switchbot") {
+		fields[metric.tags["device_id"]] = metric.fields
+	}
+
+	// status が含まれていない場合は、ゼロ値の dry として出力しない
+	require.Equal(t, map[string]map[string]any{
+		"LEAK1": {"battery": 100},
+		"LEAK2": {"battery": 90, "leak_status": "leak_detected", "leak_status_code": 1},
+	}, fields)
+}
+
+const testDeviceList = `{ "statusCode": 100, "body": { "deviceList": [
+  { "deviceId": "METER1", "deviceName": "Living", "deviceType": "MeterPlus", "hubDeviceId": "HUB1" },
+  { "deviceId": "METER2", "deviceName": "Bedroom", "deviceType": "MeterPlus", "hubDeviceId": "HUB1" },
+  { "deviceId": "BOT1", "deviceName": "Light", "deviceType": "Bot", "hubDeviceId": "HUB2" }
+], "infraredRemoteList": [] } }`
+
+func TestRefreshDevices(t *testing.T) {
+	plugin, api := newTestAPIPlugin(t, map[string]string{
+		"/v1.1/devices": testDeviceList,
+	})
+
+	// 初回の取得では、すべてのデバイスが追加されたとはみなさない
+	var accumulator testAccumulator
+	require.NoError(t, plugin.refreshDevices(t.Context(), &accumulator))
+	require.Empty(t, accumulator.metricsOf("switchbot_inventory_events"))
+	require.Len(t, plugin.deviceList, 3)
+
+	api.setResponse("/v1.1/devices", `{ "statusCode": 100, "body": { "deviceList": [
+  { "deviceId": "METER1", "deviceName": "Living", "deviceType": "MeterPlus", "hubDeviceId": "HUB1" },
+  { "deviceId": "BOT1", "deviceName": "Light", "deviceType": "Bot", "hubDeviceId": "HUB2" },
+  { "deviceId": "LOCK1", "deviceName": "Door", "deviceType": "Smart Lock", "hubDeviceId": "HUB2" }
+], "infraredRemoteList": [] } }`)
+
+	var next testAccumulator
+	require.NoError(t, plugin.refreshDevices(t.Context(), &next))
+
+	events := make(map[string]string)
+	for _, metric := range next.metricsOf("switchbot_inventory_events") {
+		require.Equal(t, map[string]any{"count": 1}, metric.fields)
+		events[metric.tags["device_id"]] = metric.tags["event"]
+	}
+	require.Equal(t, map[string]string{"LOCK1": "added", "METER2": "removed"}, events)
+	require.Contains(t, plugin.devices, "LOCK1")
+	require.NotContains(t, plugin.devices, "METER2")
+}
+
+func TestRefreshDevicesWithoutBudget(t *testing.T) {
+	plugin, api := newTestAPIPlugin(t, map[string]string{
+		"/v1.1/devices": testDeviceList,
+	})
+	plugin.SwitchBotDailyBudget = 1
+
+	var accumulator testAccumulator
+	require.NoError(t, plugin.refreshDevices(t.Context(), &accumulator))
+	fetchedAt := plugin.deviceFetchedAt
+
+	// 上限に達した後は一覧を取得せず、直近に取得したデバイスを使い続ける
+	api.setResponse("/v1.1/devices", `{ "statusCode": 100, "body": { "deviceList": [], "infraredRemoteList": [] } }`)
+	require.NoError(t, plugin.refreshDevices(t.Context(), &accumulator))
+	require.Equal(t, fetchedAt, plugin.deviceFetchedAt)
+	require.Len(t, plugin.deviceList, 3)
+	require.Empty(t, accumulator.metricsOf("switchbot_inventory_events"))
+
+	devices, err := plugin.loadDevices(t.Context(), &accumulator)
+	require.NoError(t, err)
+	require.Len(t, devices, 3)
+}
+
+func TestAddInventoryMetrics(t *testing.T) {
+	plugin, _ := newTestAPIPlugin(t, map[string]string{
+		"/v1.1/devices": testDeviceList,
+	})
+
+	var accumulator testAccumulator
+	devices, err := plugin.loadDevices(t.Context(), &accumulator)
+	require.NoError(t, err)
+	plugin.addInventoryMetrics(&accumulator, devices)
+
+	// デバイスの種類とハブごとに数える
+	counts := make(map[string]any)
+	for _, metric := range accumulator.metricsOf("switchbot_inventory") {
+		counts[metric.tags["device_type"]+"/"+metric.tags["hub_id"]] = metric.fields["devices"]
+	}
+	require.Equal(t, map[string]any{"MeterPlus/HUB1": 2, "Bot/HUB2": 1}, counts)
+}
+
+func TestAddInfraredInventoryMetrics(t *testing.T) {
+	plugin, _ := newTestAPIPlugin(t, map[string]string{
+		"/v1.1/devices": `{ "statusCode": 100, "body": { "deviceList": [], "infraredRemoteList": [
+  { "deviceId": "IR1", "deviceName": "Living AC", "remoteType": "Air Conditioner", "hubDeviceId": "HUB1" },
+  { "deviceId": "IR2", "deviceName": "Bedroom AC", "remoteType": "Air Conditioner", "hubDeviceId": "HUB1" },
+  { "deviceId": "IR3", "deviceName": "TV", "remoteType": "TV", "hubDeviceId": "HUB2" }
+] } }`,
+	})
+
+	var accumulator testAccumulator
+	devices, err := plugin.loadDevices(t.Context(), &accumulator)
+	require.NoError(t, err)
+	plugin.addInventoryMetrics(&accumulator, devices)
+
+	// 赤外線リモコンはリモコンの種類とハブごとに数える
+	counts := make(map[string]any)
+	for _, metric := range accumulator.metricsOf("switchbot_infrared_inventory") {
+		counts[metric.tags["remote_type"]+"/"+metric.tags["hub_id"]] = metric.fields["remotes"]
+	}
+	require.Equal(t, map[string]any{"Air Conditioner/HUB1": 2, "TV/HUB2": 1}, counts)
+	require.Empty(t, accumulator.metricsOf("switchbot_inventory"))
+}
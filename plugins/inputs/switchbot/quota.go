@@ -0,0 +1,128 @@
+package switchbot
+
+import (
+	"errors"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/nasa9084/go-switchbot/v5"
+)
+
+var errDailyBudgetExhausted = errors.New("daily budget exhausted")
+
+// 変化の遅いデバイスは、他のデバイスのこの倍の間隔でポーリングする
+const slowPollFactor = 4
+
+// slowChangingDevices は温湿度計のように値の変化が遅いデバイス
+var slowChangingDevices = map[switchbot.PhysicalDeviceType]struct{}{
+	switchbot.Meter:       {},
+	switchbot.MeterPlus:   {},
+	switchbot.MeterPro:    {},
+	switchbot.MeterProCO2: {},
+	switchbot.WoIOSensor:  {},
+	switchbot.Hub2:        {},
+}
+
+// consumeRequest は API のリクエストを 1 回分数え、当日の上限に達している場合は false を返す
+func (p *Plugin) consumeRequest(now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.resetQuotaIfNewDay(now)
+	if p.requestsToday >= p.SwitchBotDailyBudget {
+		return false
+	}
+
+	p.requestsToday++
+	return true
+}
+
+// resetQuotaIfNewDay は日付が変わっていればリクエスト数を数え直す。p.mu を保持して呼び出す
+func (p *Plugin) resetQuotaIfNewDay(now time.Time) {
+	year, month, day := now.Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+	if !p.quotaDay.Equal(today) {
+		p.quotaDay = today
+		p.requestsToday = 0
+	}
+}
+
+// estimatedListRequests は当日の残りの時間にデバイスの一覧の取得に使うリクエスト数を見積もる
+func (p *Plugin) estimatedListRequests(remaining time.Duration) int {
+	return int(remaining / p.SwitchBotDeviceListTTL)
+}
+
+// pollIntervals は当日の残りのリクエスト数を使い切らないよう、デバイスごとのポーリング間隔を求める
+func (p *Plugin) pollIntervals(devices []switchbot.Device, now time.Time) map[string]time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.resetQuotaIfNewDay(now)
+
+	intervals := make(map[string]time.Duration, len(devices))
+	if len(devices) == 0 {
+		return intervals
+	}
+
+	remainingTime := p.quotaDay.AddDate(0, 0, 1).Sub(now)
+	remainingRequests := p.SwitchBotDailyBudget - p.requestsToday - p.estimatedListRequests(remainingTime)
+
+	// 変化の遅いデバイスの重みを小さくし、重みに比例してリクエストを割り振る
+	var totalWeight float64
+	for _, device := range devices {
+		totalWeight += 1 / pollFactor(device.Type)
+	}
+
+	for _, device := range devices {
+		if remainingRequests <= 0 {
+			intervals[device.ID] = remainingTime
+			continue
+		}
+
+		share := float64(remainingRequests) * (1 / pollFactor(device.Type)) / totalWeight
+		intervals[device.ID] = time.Duration(float64(remainingTime) / share)
+	}
+
+	return intervals
+}
+
+func pollFactor(deviceType switchbot.PhysicalDeviceType) float64 {
+	if _, ok := slowChangingDevices[deviceType]; ok {
+		return slowPollFactor
+	}
+
+	return 1
+}
+
+// reservePoll は前回のポーリングから interval 以上経過しており、当日の上限に達していなければ
+// リクエストを 1 回分数えてポーリングした時刻を記録し、true を返す
+func (p *Plugin) reservePoll(id string, interval time.Duration, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if polledAt, ok := p.lastPolledAt[id]; ok && now.Sub(polledAt) < interval {
+		return false
+	}
+
+	p.resetQuotaIfNewDay(now)
+	if p.requestsToday >= p.SwitchBotDailyBudget {
+		return false
+	}
+
+	p.requestsToday++
+	p.lastPolledAt[id] = now
+	return true
+}
+
+func (p *Plugin) addQuotaMetrics(accumulator telegraf.Accumulator, polled, skipped int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	accumulator.AddFields("switchbot_api", map[string]any{
+		"requests_today":  p.requestsToday,
+		"budget":          p.SwitchBotDailyBudget,
+		"remaining":       max(p.SwitchBotDailyBudget-p.requestsToday, 0),
+		"polled_devices":  polled,
+		"skipped_devices": skipped,
+	}, nil)
+}
@@ -0,0 +1,74 @@
+package switchbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// statusKeysRecorder はステータスの取得 API の応答に含まれていたキーをデバイスごとに記録する
+// DeviceStatus ではゼロ値と値が含まれていないことを区別できないため、応答の JSON から判定する
+type statusKeysRecorder struct {
+	transport http.RoundTripper
+
+	mu   sync.Mutex
+	keys map[string]map[string]json.RawMessage
+}
+
+func newStatusKeysRecorder(transport http.RoundTripper) *statusKeysRecorder {
+	return &statusKeysRecorder{
+		transport: transport,
+		keys:      make(map[string]map[string]json.RawMessage),
+	}
+}
+
+func (r *statusKeysRecorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := r.transport.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+
+	id, ok := statusDeviceID(request.URL.Path)
+	if !ok || response.StatusCode != http.StatusOK {
+		return response, nil
+	}
+
+	body, err := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(body))
+
+	var payload struct {
+		Body map[string]json.RawMessage `json:"body"`
+	}
+	if err = json.Unmarshal(body, &payload); err == nil {
+		r.mu.Lock()
+		r.keys[id] = payload.Body
+		r.mu.Unlock()
+	}
+
+	return response, nil
+}
+
+// statusKeys は直近に取得したデバイスのステータスに含まれていたキーを返す
+func (r *statusKeysRecorder) statusKeys(id string) map[string]json.RawMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.keys[id]
+}
+
+// statusDeviceID は /v1.1/devices/{id}/status からデバイス ID を取り出す
+func statusDeviceID(path string) (string, bool) {
+	id, ok := strings.CutPrefix(path, "/v1.1/devices/")
+	if !ok {
+		return "", false
+	}
+
+	return strings.CutSuffix(id, "/status")
+}
@@ -0,0 +1,247 @@
+package switchbot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/nasa9084/go-switchbot/v5"
+)
+
+// Webhook のリクエストボディの上限
+const maxWebhookBodySize = 1 << 20
+
+type webhookPayload struct {
+	EventType    string          `json:"eventType"`
+	EventVersion string          `json:"eventVersion"`
+	Context      json.RawMessage `json:"context"`
+}
+
+type webhookContext struct {
+	DeviceType   string `json:"deviceType"`
+	DeviceMac    string `json:"deviceMac"`
+	TimeOfSample int64  `json:"timeOfSample"`
+}
+
+// webhookKeyConverters は Webhook のペイロードのキーと値を、ステータスの取得 API と同じキーと値に変換する
+// 人感センサー、開閉センサー、水漏れセンサーは、状態をステータスとは異なる detectionState として送る
+// (go-switchbot の MotionSensorEvent、ContactSensorEvent、WaterLeakDetectorEvent を参照)
+var webhookKeyConverters = map[switchbot.PhysicalDeviceType]func(keys map[string]json.RawMessage) error{
+	switchbot.MotionSensor:  convertMoveDetected,
+	switchbot.ContactSensor: convertMoveDetected,
+	"Water Detector":        convertLeakStatus,
+}
+
+// convertMoveDetected は "DETECTED" または "NOT_DETECTED" の detectionState を moveDetected に変換する
+func convertMoveDetected(keys map[string]json.RawMessage) error {
+	value, ok := keys["detectionState"]
+	if !ok {
+		return nil
+	}
+
+	var state string
+	if err := json.Unmarshal(value, &state); err != nil {
+		return fmt.Errorf("failed to decode detectionState: %w", err)
+	}
+
+	delete(keys, "detectionState")
+	keys["moveDetected"] = json.RawMessage(strconv.FormatBool(strings.EqualFold(state, "DETECTED")))
+	return nil
+}
+
+// convertLeakStatus は 0 (乾燥) または 1 (水漏れ) の detectionState を status に変換する
+func convertLeakStatus(keys map[string]json.RawMessage) error {
+	if value, ok := keys["detectionState"]; ok {
+		delete(keys, "detectionState")
+		keys["status"] = value
+	}
+
+	return nil
+}
+
+func (p *Plugin) startWebhook(accumulator telegraf.Accumulator) error {
+	listener, err := net.Listen("tcp", p.SwitchBotWebhookListen)
+	if err != nil {
+		return fmt.Errorf("failed to listen webhook: %w", err)
+	}
+
+	p.server = &http.Server{
+		Handler:           p.webhookHandler(accumulator),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	p.wg.Go(func() {
+		if err := p.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			accumulator.AddError(fmt.Errorf("webhook server stopped: %w", err))
+		}
+	})
+
+	if p.SwitchBotWebhookURL != "" {
+		if err := p.registerWebhook(context.Background()); err != nil {
+			// 登録に失敗しても、手動で登録された Webhook は受け付けられるように続行する
+			p.Log.Warnf("failed to register webhook: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (p *Plugin) webhookHandler(accumulator telegraf.Accumulator) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+p.SwitchBotWebhookPath, func(w http.ResponseWriter, r *http.Request) {
+		if err := p.handleWebhook(accumulator, r); err != nil {
+			p.Log.Warnf("rejected webhook from %s: %v", r.RemoteAddr, err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return mux
+}
+
+func (p *Plugin) stopWebhook() {
+	if p.server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := p.server.Shutdown(ctx); err != nil {
+		p.Log.Warnf("failed to shutdown webhook server: %v", err)
+	}
+}
+
+func (p *Plugin) registerWebhook(ctx context.Context) error {
+	// Webhook はアカウントにつき 1 つしか登録できないため、登録済みなら何もしない
+	if !p.consumeRequest(time.Now()) {
+		return errDailyBudgetExhausted
+	}
+	url, err := p.client.Webhook().QueryUrl(ctx)
+	if err == nil && url == p.SwitchBotWebhookURL {
+		return nil
+	}
+
+	if !p.consumeRequest(time.Now()) {
+		return errDailyBudgetExhausted
+	}
+	if err = p.client.Webhook().Setup(ctx, p.SwitchBotWebhookURL, "ALL"); err != nil {
+		return fmt.Errorf("failed to setup webhook: %w", err)
+	}
+
+	return nil
+}
+
+func (p *Plugin) handleWebhook(accumulator telegraf.Accumulator, r *http.Request) error {
+	// SwitchBot の Webhook は署名されないため、URL に含めたトークンで送信元を検証する
+	token := r.URL.Query().Get("token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(p.SwitchBotWebhookToken)) != 1 {
+		return errors.New("invalid token")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodySize))
+	if err != nil {
+		return fmt.Errorf("failed to read body: %w", err)
+	}
+
+	var payload webhookPayload
+	if err = json.Unmarshal(body, &payload); err != nil {
+		return fmt.Errorf("failed to decode payload: %w", err)
+	}
+	if payload.EventType != "changeReport" {
+		return fmt.Errorf("unexpected event type: %s", payload.EventType)
+	}
+
+	var eventContext webhookContext
+	if err = json.Unmarshal(payload.Context, &eventContext); err != nil {
+		return fmt.Errorf("failed to decode context: %w", err)
+	}
+
+	device, ok := p.lookupDevice(eventContext.DeviceMac)
+	if !ok {
+		return fmt.Errorf("unknown device: %s", eventContext.DeviceMac)
+	}
+
+	// 値が含まれているキーのみを出力するため、ステータスとは別にキーの一覧を取得する
+	var keys map[string]json.RawMessage
+	if err = json.Unmarshal(payload.Context, &keys); err != nil {
+		return fmt.Errorf("failed to decode context: %w", err)
+	}
+	if convert, ok := webhookKeyConverters[device.Type]; ok {
+		if err = convert(keys); err != nil {
+			return err
+		}
+	}
+
+	converted, err := json.Marshal(keys)
+	if err != nil {
+		return fmt.Errorf("failed to encode context: %w", err)
+	}
+
+	var status switchbot.DeviceStatus
+	if err = json.Unmarshal(converted, &status); err != nil {
+		return fmt.Errorf("failed to decode status: %w", err)
+	}
+
+	metrics := SupportedMetrics[device.Type]
+	fields := map[string]any{}
+	for _, m := range metrics {
+		if _, ok := keys[m.JSONKey]; ok {
+			fields[m.Key] = m.Value(&status)
+		}
+	}
+
+	// 一部のキーしか含まれない Webhook しか届かないデバイスは、残りのキーを取得するためにポーリングを続ける
+	if len(fields) == len(metrics) {
+		p.markPushed(device.ID)
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	timestamp := time.Now()
+	if eventContext.TimeOfSample > 0 {
+		timestamp = time.UnixMilli(eventContext.TimeOfSample)
+	}
+
+	accumulator.AddFields("switchbot", fields, deviceTags(device), timestamp)
+	return nil
+}
+
+// lookupDevice は Webhook の MAC アドレスから、直近に取得したデバイスを探す
+func (p *Plugin) lookupDevice(mac string) (*switchbot.Device, bool) {
+	id := strings.ToUpper(strings.ReplaceAll(mac, ":", ""))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	device, ok := p.devices[id]
+	return device, ok
+}
+
+func (p *Plugin) markPushed(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lastPushedAt[id] = time.Now()
+}
+
+// isPushed は Webhook で最近ステータスが届いたデバイスかどうかを返す
+func (p *Plugin) isPushed(id string, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pushedAt, ok := p.lastPushedAt[id]
+	return ok && now.Sub(pushedAt) < p.SwitchBotWebhookStaleAfter
+}